@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// expandInputPatterns returns the input files named by the -batch arguments,
+// in order and without duplicates. Glob patterns are expanded, skipping files
+// that look like earlier batch output, so that "*.txt" does not pick up
+// foo.out.txt. An argument that matches nothing is kept as given and reported
+// as missing when it is processed.
+func expandInputPatterns(patterns []string) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil || len(matches) == 0 || matches[0] == pattern {
+			add(pattern)
+			continue
+		}
+		for _, match := range matches {
+			if !isBatchOutput(match) {
+				add(match)
+			}
+		}
+	}
+	return paths
+}
+
+// batchOutputPath returns the output file for a batch input file: foo.txt
+// becomes foo.out.txt, next to the input or in outDir when it is set.
+func batchOutputPath(inputPath, outDir string) string {
+	extension := filepath.Ext(inputPath)
+	output := strings.TrimSuffix(inputPath, extension) + ".out" + extension
+	if outDir != "" {
+		output = filepath.Join(outDir, filepath.Base(output))
+	}
+	return output
+}
+
+// isBatchOutput reports whether path is named like a batch output file.
+func isBatchOutput(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, filepath.Ext(path)), ".out")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// airportCachePath returns the cache file for a lookup file, e.g.
+// "airport-lookup.csv.cache" for "airport-lookup.csv", so that lookup files
+// differing only in extension do not share a cache.
+func airportCachePath(path string) string {
+	return path + ".cache"
+}
+
+// airportCache is the content of a cache file. The source path and
+// delimiter it was parsed with are kept so that a cache is only used for
+// the same file read the same way.
+type airportCache struct {
+	Source    string
+	Delimiter rune
+	Airports  []*formatter.Airport
+}
+
+// readCachedAirports reads airports from the cache of the lookup file at
+// path when the cache is newer than the file, and from the file otherwise,
+// refreshing the cache. A cache that cannot be read or written is ignored.
+func (c *config) readCachedAirports(path string) ([]*formatter.Airport, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	delimiter := c.lookupDelimiter(path)
+	cachePath := airportCachePath(path)
+	if cacheInfo, err := os.Stat(cachePath); err == nil && cacheInfo.ModTime().After(info.ModTime()) {
+		cache, err := readAirportCache(cachePath)
+		switch {
+		case err != nil:
+			c.logf("ignoring unreadable airport cache %s", cachePath)
+		case cache.Source != source || cache.Delimiter != delimiter:
+			c.logf("ignoring airport cache %s made for %s with delimiter %q", cachePath, cache.Source, cache.Delimiter)
+		default:
+			c.logf("read airport data from cache %s", cachePath)
+			return cache.Airports, nil
+		}
+	}
+
+	airports, err := c.readAirportFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cache := airportCache{Source: source, Delimiter: delimiter, Airports: airports}
+	if err := c.writeAirportCache(cachePath, cache); err != nil {
+		c.logf("could not write airport cache: %v", err)
+	}
+	return airports, nil
+}
+
+// readAirportCache decodes a cache file.
+func readAirportCache(path string) (airportCache, error) {
+	var cache airportCache
+	file, err := os.Open(path)
+	if err != nil {
+		return cache, err
+	}
+	defer file.Close()
+	err = gob.NewDecoder(file).Decode(&cache)
+	return cache, err
+}
+
+// writeAirportCache encodes cache to a cache file, replacing it atomically
+// so a concurrent run never reads half a cache.
+func (c *config) writeAirportCache(path string, cache airportCache) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(cache); err != nil {
+		return err
+	}
+	return c.writeFileAtomic(path, data.Bytes())
+}
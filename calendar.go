@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// calendarEvent is one date or time placeholder found in processed content.
+type calendarEvent struct {
+	start   time.Time
+	allDay  bool   // the placeholder was a date rather than a time
+	summary string // the processed line the placeholder appeared on
+}
+
+// eventCollector is a renderer that renders plain text while recording the
+// timestamp of every date and time value it sees.
+type eventCollector struct {
+	values []formatter.Value
+}
+
+func (c *eventCollector) Text(text string) string { return text }
+
+func (c *eventCollector) Value(value formatter.Value) string {
+	if (value.Kind == formatter.ValueDate || value.Kind == formatter.ValueTime) && value.Code != "" {
+		c.values = append(c.values, value)
+	}
+	return value.Text
+}
+
+// calendarEvents returns an event for each date and time placeholder in
+// processed content, summarized by the plain text of its line.
+func calendarEvents(content string) []calendarEvent {
+	var events []calendarEvent
+	for _, line := range strings.Split(content, "\n") {
+		collector := &eventCollector{}
+		summary := strings.TrimSpace(formatter.Render(line, collector))
+		for _, value := range collector.values {
+			start, err := time.Parse(time.RFC3339, value.Code)
+			if err != nil {
+				continue
+			}
+			events = append(events, calendarEvent{start: start, allDay: value.Kind == formatter.ValueDate, summary: summary})
+		}
+	}
+	return events
+}
+
+// icsEscaper escapes text for an iCalendar TEXT property value.
+var icsEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\n", `\n`)
+
+// icsCalendar builds a minimal iCalendar document with one VEVENT per event.
+// Dates become all-day events on their local date; times start at their
+// instant in UTC. stamp is used for each event's DTSTAMP.
+func icsCalendar(events []calendarEvent, stamp time.Time) string {
+	var b strings.Builder
+	writeLine := func(line string) {
+		b.WriteString(foldICSLine(line))
+		b.WriteString("\r\n")
+	}
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//Text-Formatter//Itinerary//EN")
+	for i, event := range events {
+		writeLine("BEGIN:VEVENT")
+		writeLine(fmt.Sprintf("UID:%d-%s@text-formatter", i+1, event.start.UTC().Format("20060102T150405Z")))
+		writeLine("DTSTAMP:" + stamp.UTC().Format("20060102T150405Z"))
+		if event.allDay {
+			writeLine("DTSTART;VALUE=DATE:" + event.start.Format("20060102"))
+		} else {
+			writeLine("DTSTART:" + event.start.UTC().Format("20060102T150405Z"))
+		}
+		writeLine("SUMMARY:" + icsEscaper.Replace(event.summary))
+		writeLine("END:VEVENT")
+	}
+	writeLine("END:VCALENDAR")
+	return b.String()
+}
+
+// foldICSLine folds a content line longer than the 75 octets iCalendar
+// allows, continuing it on lines that start with a space. It never splits a
+// UTF-8 sequence.
+func foldICSLine(line string) string {
+	const limit = 75
+	var b strings.Builder
+	for len(line) > limit {
+		cut := limit
+		if b.Len() > 0 {
+			cut-- // continuation lines spend one octet on the leading space
+		}
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	return b.String()
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// config holds everything a run needs, parsed from the command line and
+// the TEXTFMT_* environment. The CLI keeps no package-level state: each mode
+// reads its settings from a config, so tests can build one directly.
+type config struct {
+	opts formatter.Options
+
+	// Modes that print something and exit.
+	showUsage   bool
+	syntaxHelp  bool
+	pipeline    bool
+	dumpGrammar bool
+	lookup      string // comma-separated codes for -lookup
+
+	// Input and airport data.
+	batch             bool
+	inputPaths        []string
+	airportLookupPath string // "" for the embedded airport data
+	addedAirports     airportOverrides
+	useAirportCache   bool
+	airportDelimiter  rune // zero picks one from the file extension
+	maxFileSize       int64
+	base              string
+
+	// Checks and additions to the document.
+	strict          bool
+	requirements    []requirement
+	appendix        bool
+	appendixColumns []string
+	embedWarnings   bool
+	ics             string
+	legs            bool
+	oneline         bool
+	parallelLines   int
+
+	// Output.
+	outputPath          string // "" for stdout
+	outDir              string
+	dryRun              bool
+	jsonResult          bool
+	expect              string
+	format              string
+	formats             string
+	fileRenderer        formatter.Renderer
+	sideBySide          bool
+	airportLinkTemplate string
+	outputEncoding      string // "" writes UTF-8 unchanged
+	replaceUnmappable   bool
+	appendOutput        bool
+	writeRetries        int
+
+	// Terminal, logging and profiling.
+	stdout         io.Writer
+	stderr         io.Writer
+	status         io.Writer // receives error, warning and success messages
+	colors         theme
+	noColor        bool
+	forceColor     bool
+	colorByCountry bool
+	countryColors  map[string]string // palette color of each country seen so far
+	highlightPast  bool
+	timing         bool
+	logPath        string
+	runLog         *log.Logger // nil when logging is off
+	cpuProfile     string
+	memProfile     string
+}
+
+// newConfig returns a config with the defaults of every flag, writing to
+// stdout and stderr.
+func newConfig(stdout, stderr io.Writer) *config {
+	return &config{
+		opts:            formatter.DefaultOptions(),
+		useAirportCache: true,
+		appendixColumns: []string{"name", "iata", "icao"},
+		fileRenderer:    formatter.PlainRenderer{},
+		stdout:          stdout,
+		stderr:          stderr,
+		status:          stderr,
+		colors:          defaultTheme(),
+	}
+}
+
+// rawFlags holds the flag values that are checked or converted before they
+// are stored in a config.
+type rawFlags struct {
+	maxAirports, maxDates, maxTimes int
+	onUnmappable                    string
+	trimPolicy                      string
+	trimExempt                      string
+	now                             string
+	statusStream                    string
+	defaultCity                     bool
+	icaoLength                      string
+	noCache                         bool
+	delimiter                       string
+	handler                         string
+	handlerPrefixes                 string
+	fallbackChain                   string
+	columns                         string
+	require                         string
+	airports                        string
+	theme                           string
+}
+
+// usageError is a command-line error after which the usage is printed.
+type usageError struct{ error }
+
+// errFlagSyntax reports a command line the flag package could not parse. The
+// flag package has already printed the problem and the flags.
+var errFlagSyntax = errors.New("invalid command line")
+
+// parseConfig parses the command-line arguments, without the program name,
+// into a config. The config is returned even with an error so that the
+// error can be printed where -status-stream says.
+func parseConfig(args []string, stdout, stderr io.Writer) (*config, error) {
+	c := newConfig(stdout, stderr)
+	var raw rawFlags
+	flags := c.flagSet(&raw)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return c, err
+		}
+		return c, errFlagSyntax
+	}
+	if err := applyEnvDefaults(flags); err != nil {
+		return c, err
+	}
+	if err := c.setTerminal(&raw); err != nil {
+		return c, err
+	}
+	if c.showUsage || c.syntaxHelp {
+		return c, nil
+	}
+	if flags.NArg() == 0 && !c.pipeline && !c.dumpGrammar && c.lookup == "" {
+		c.showUsage = true
+		return c, nil
+	}
+	if err := c.setFormatterOptions(&raw); err != nil {
+		return c, err
+	}
+	if c.pipeline || c.dumpGrammar {
+		return c, nil
+	}
+	if err := c.setOutput(&raw); err != nil {
+		return c, err
+	}
+	return c, c.setPaths(&raw, flags.Args())
+}
+
+// flagSet defines every flag, storing its value in c or, when it needs
+// checking first, in raw.
+func (c *config) flagSet(raw *rawFlags) *flag.FlagSet {
+	opts := &c.opts
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+
+	// Define a flag for displaying help.
+	flags.BoolVar(&c.showUsage, "h", false, "Display usage information")
+	flags.BoolVar(&c.syntaxHelp, "help-syntax", false, "Print every placeholder form with an expanded example")
+	flags.IntVar(&raw.maxAirports, "max-airports", 0, "Maximum number of airport codes to expand (0 = unlimited)")
+	flags.IntVar(&raw.maxDates, "max-dates", 0, "Maximum number of D(...) dates to expand (0 = unlimited)")
+	flags.IntVar(&raw.maxTimes, "max-times", 0, "Maximum number of T12/T24(...) times to expand (0 = unlimited)")
+	flags.BoolVar(&opts.Annotate, "annotate", false, "Keep airport codes and append the expansion in brackets")
+	flags.StringVar(&c.formats, "formats", "", "Comma-separated output formats to write in one pass: plain, html, markdown")
+	flags.StringVar(&c.outDir, "out-dir", "", "Directory for -formats and -batch output files (default: the output or input file's directory)")
+	flags.BoolVar(&opts.RespectCodeFences, "respect-code-fences", false, "Leave placeholders inside fenced code blocks unexpanded")
+	flags.BoolVar(&opts.NormalizeUnresolvedCase, "normalize-unresolved-case", false, "Uppercase airport code tokens that cannot be resolved")
+	flags.BoolVar(&c.embedWarnings, "embed-warnings", false, "Prepend warnings such as unresolved codes as a header in the output file")
+	flags.BoolVar(&opts.ShowMatchedCode, "show-matched-code-only", false, "Append (via IATA) or (via ICAO) to each expanded airport")
+	flags.StringVar(&c.outputEncoding, "output-encoding", "", "Encoding for output files, e.g. iso-8859-1 (default UTF-8)")
+	flags.StringVar(&raw.onUnmappable, "on-unmappable", "error", "What to do with characters the output encoding lacks: error or replace")
+	flags.StringVar(&c.cpuProfile, "cpuprofile", "", "Write a CPU profile of the run to this file")
+	flags.StringVar(&c.memProfile, "memprofile", "", "Write a heap profile at the end of the run to this file")
+	flags.StringVar(&opts.ListSeparator, "list-separator", ", ", "Separator between airports expanded from a #[...] list")
+	flags.BoolVar(&opts.StripANSI, "strip-ansi-input", false, "Remove ANSI color codes from the input before processing")
+	flags.BoolVar(&opts.StripInvisible, "strip-invisible", false, "Remove zero-width and control characters before processing")
+	flags.BoolVar(&c.appendix, "appendix", false, "Append a list of the referenced airports grouped by country")
+	flags.BoolVar(&c.sideBySide, "side-by-side", false, "Show each original line next to its processed line")
+	flags.StringVar(&c.airportLinkTemplate, "link-template", "", "URL template for airport links in html/markdown output, using {code} and {name}")
+	flags.StringVar(&raw.require, "require", "", "Minimum placeholder counts, e.g. \"dates>=1,airports>=2\"; fails the run if unmet")
+	flags.StringVar(&opts.MapURLTemplate, "map-url-template", opts.MapURLTemplate, "URL template for #mapABC placeholders, using {lat} and {lon}")
+	flags.StringVar(&raw.trimPolicy, "trim-policy", "aggressive", "Whitespace cleanup: aggressive, trailing, indent-safe, tabs-safe or none")
+	flags.BoolVar(&c.timing, "timing", false, "Print how long loading and processing took, with throughput, to stderr")
+	flags.BoolVar(&opts.PreferDisplayName, "prefer-display-name", false, "Expand airports to the display_name column when the airport data has one")
+	flags.Int64Var(&c.maxFileSize, "max-file-size", defaultMaxFileSize, "Refuse input files larger than this many bytes (0 = unlimited)")
+	flags.StringVar(&raw.now, "now", "", "Reference time for DREL(...) placeholders, e.g. 2023-05-01T15:04Z (default: the current time)")
+	flags.StringVar(&raw.statusStream, "status-stream", "stderr", "Where to print errors, warnings and success messages: stderr or stdout")
+	flags.BoolVar(&c.colorByCountry, "color-by-country", false, "Color highlighted airports by country in the terminal output")
+	flags.BoolVar(&opts.StrictCodeBoundary, "strict-code-boundary", false, "Leave airport codes followed by a letter or digit, e.g. #LAX2, unexpanded")
+	flags.StringVar(&c.ics, "ics", "", "Also write an iCalendar file with an event for each date and time placeholder")
+	flags.StringVar(&opts.DefaultForm, "default-airport-form", formatter.AirportFormName, "How airport codes expand without the * prefix: name, city or code")
+	flags.BoolVar(&c.appendOutput, "append", false, "Append to the output file instead of replacing it")
+	flags.BoolVar(&opts.ReverseDates, "reverse-dates", false, "Turn formatted dates such as 01 May 2023 back into D(...), DW(...) or DLONG(...) placeholders instead of expanding placeholders")
+	flags.BoolVar(&c.jsonResult, "json-result", false, "Print only a JSON object with the output, unresolved codes and counts; no output file is written")
+	flags.BoolVar(&opts.TrailingStar, "trailing-star", false, "Also treat a * after an airport token, as in #LAX*, as the city prefix")
+	flags.StringVar(&c.logPath, "log", "", "Append a timestamped log of the run to this file")
+	flags.BoolVar(&c.highlightPast, "highlight-past", false, "Show dates before now (or -now) in gray in the terminal output")
+	flags.BoolVar(&opts.NormalizeCodes, "normalize-codes", false, "Rewrite airport code tokens in uppercase canonical form instead of expanding placeholders")
+	flags.StringVar(&opts.TZWrap, "tz-wrap", opts.TZWrap, "Pattern the timezone of a time is shown in, with %s for the zone, e.g. [%s] or %s")
+	flags.BoolVar(&c.pipeline, "pipeline", false, "Print the processing steps the current options enable, in order, and exit")
+	flags.BoolVar(&opts.ConsumeBrackets, "consume-brackets", false, "Remove the brackets around a code written as [#LAX] when expanding it")
+	flags.StringVar(&opts.NameLanguage, "name-language", "", "Expand airports to the localized name from the name_xx column for this language, e.g. fr")
+	flags.IntVar(&c.writeRetries, "write-retries", 0, "How many more times to attempt a failed output file write")
+	flags.BoolVar(&c.legs, "legs", false, "Add Outbound and Return headers when the airports describe a round trip")
+	flags.BoolVar(&opts.Verbose, "verbose", false, "Also warn about unresolved codes, airports whose data is incomplete and malformed or unknown date placeholders")
+	flags.StringVar(&raw.columns, "columns", "", "Airport fields listed by -appendix, in order, e.g. name,iata,city")
+	flags.StringVar(&c.base, "base", "", "Previous version of the input; lines unchanged from it are copied from the existing output file")
+	flags.StringVar(&raw.fallbackChain, "fallback-chain", "city,name,code", "Order of airport forms tried when the requested form is empty")
+	flags.BoolVar(&opts.PreserveFormFeed, "preserve-formfeed", false, "Keep form feeds as page-break markers instead of turning them into line breaks")
+	flags.StringVar(&raw.handler, "placeholder-handler", "", "Command that expands PREFIX(...) placeholders for -handler-prefixes, reading the content on stdin")
+	flags.StringVar(&raw.handlerPrefixes, "handler-prefixes", "", "Comma-separated placeholder prefixes passed to -placeholder-handler, e.g. X,WX")
+	flags.DurationVar(&opts.HandlerTimeout, "handler-timeout", opts.HandlerTimeout, "How long -placeholder-handler may run for each placeholder")
+	flags.BoolVar(&c.strict, "strict", false, "Fail when any airport code cannot be resolved, listing the IATA and ICAO codes missed with their line numbers")
+	flags.BoolVar(&opts.NormalizeWidth, "normalize-width", false, "Convert full-width letters and symbols, as in ＃ＬＡＸ, to ASCII before processing")
+	flags.Var(&c.addedAirports, "add-airport", "Add or override an airport for this run, as CODE=Name[,City[,Country]]; may be repeated")
+	flags.BoolVar(&c.oneline, "oneline", false, "Output a one-line summary of the route and date range instead of the document")
+	flags.StringVar(&c.expect, "expect", "", "Compare the output with this golden `file` and fail, printing the differences, if they differ")
+	flags.StringVar(&opts.CityCountrySeparator, "city-country-sep", "", "Separator between city and country in city expansions, e.g. \", \" for \"Los Angeles, US\" (default: city only)")
+	flags.BoolVar(&c.dumpGrammar, "dump-grammar", false, "Print the placeholder patterns the current options recognize as JSON and exit")
+	flags.IntVar(&c.parallelLines, "parallel-lines", 0, "Expand placeholders in this many chunks of lines concurrently (default: serially)")
+	flags.BoolVar(&c.noColor, "no-color", false, "Print the processed output and status messages without ANSI colors")
+	flags.BoolVar(&c.forceColor, "color", false, "Print the processed output and status messages with ANSI colors even when they do not go to a terminal")
+	flags.BoolVar(&opts.ReverseNames, "reverse", false, "Turn airport names such as Los Angeles International Airport back into #ABC codes instead of expanding placeholders")
+	flags.StringVar(&c.format, "format", "text", "Output format: text, html with a span around each expanded value, markdown, or json to list each placeholder found instead of rewriting the input")
+	flags.BoolVar(&raw.noCache, "no-cache", false, "Parse the airport lookup file without reading or writing its .cache file")
+	flags.BoolVar(&opts.TimeSeconds, "time-seconds", false, "Show seconds in T12 and T24 times, e.g. 14:30:05")
+	flags.StringVar(&raw.delimiter, "delimiter", "", "Field separator of the airport lookup file: one character, or tab (default: tab for .tsv, | for .psv, otherwise comma)")
+	flags.StringVar(&opts.CoordinateFormat, "coordinate-format", opts.CoordinateFormat, "How C(#ABC) shows coordinates: raw as stored, decimal rounded with hemispheres, or latlon")
+	flags.StringVar(&raw.icaoLength, "icao-length", "4", "Length of codes written as ##CODE: a number, or a range such as 3-5 for private and military fields")
+	flags.BoolVar(&c.dryRun, "dry-run", false, "Process the input and print what would change, with a preview, without writing any file")
+	flags.StringVar(&raw.trimExempt, "trim-exempt", "", "Keep the whitespace of lines matching this regular expression, e.g. ^\\t for tab-indented lines")
+	flags.BoolVar(&opts.TrimExemptFences, "trim-exempt-fences", false, "Keep the whitespace of lines in ``` fenced code blocks")
+	flags.BoolVar(&opts.ExpandEscapes, "escapes", false, "Turn the escape sequences \\n, \\t, \\r, \\v and \\f written in the input into the characters they stand for")
+	flags.BoolVar(&c.batch, "batch", false, "Treat every argument as an input file or glob pattern, writing each foo.txt to foo.out.txt")
+	flags.StringVar(&raw.airports, "airports", "", "Airport lookup file, for -batch and -lookup or instead of the third argument (default: the embedded airport data)")
+	flags.BoolVar(&raw.defaultCity, "default-city", false, "Shorthand for -default-airport-form city: #ABC expands to the city and *#ABC to the name")
+	flags.StringVar(&c.lookup, "lookup", "", "Print the airport data for these comma-separated codes, e.g. JFK,EGLL, and exit")
+	flags.StringVar(&raw.theme, "theme", "", "Theme file mapping roles such as airport, date and error to colors (default: ~/.itinerary.toml if it exists)")
+	flags.StringVar(&opts.TZStyle, "tz-style", formatter.TZStyleOffset, "Timezone display for times: offset or abbrev")
+	return flags
+}
+
+// setTerminal picks the stream for status messages and loads the theme.
+func (c *config) setTerminal(raw *rawFlags) error {
+	switch raw.statusStream {
+	case "stderr":
+		c.status = c.stderr
+	case "stdout":
+		c.status = c.stdout
+	default:
+		return fmt.Errorf("Invalid -status-stream %q (expected stderr or stdout)", raw.statusStream)
+	}
+
+	themePath := raw.theme
+	if themePath == "" {
+		if path := defaultThemePath(); path != "" && fileExists(path) {
+			themePath = path
+		}
+	}
+	if themePath != "" {
+		loaded, err := loadTheme(themePath)
+		if err != nil {
+			return fmt.Errorf("Invalid theme file %s: %v", themePath, err)
+		}
+		c.colors = loaded
+	}
+	return nil
+}
+
+// setFormatterOptions checks the flags that configure the formatter and
+// completes c.opts from them.
+func (c *config) setFormatterOptions(raw *rawFlags) error {
+	opts := &c.opts
+	if opts.TZStyle != formatter.TZStyleOffset && opts.TZStyle != formatter.TZStyleAbbrev {
+		return fmt.Errorf("Invalid -tz-style %q (expected %s or %s)", opts.TZStyle, formatter.TZStyleOffset, formatter.TZStyleAbbrev)
+	}
+	if strings.Count(opts.TZWrap, "%s") != 1 {
+		return fmt.Errorf("Invalid -tz-wrap %q (expected exactly one %%s)", opts.TZWrap)
+	}
+	// Named zones need the IANA timezone database, which minimal systems lack.
+	if opts.TZStyle == formatter.TZStyleAbbrev {
+		if err := formatter.CheckTimezoneDatabase(); err != nil {
+			return err
+		}
+	}
+
+	policy, exists := formatter.TrimPolicies[raw.trimPolicy]
+	if !exists {
+		return fmt.Errorf("Invalid -trim-policy %q (expected aggressive, trailing, indent-safe, tabs-safe or none)", raw.trimPolicy)
+	}
+	opts.TrimPolicy = policy
+	if raw.trimExempt != "" {
+		exempt, err := regexp.Compile(raw.trimExempt)
+		if err != nil {
+			return fmt.Errorf("Invalid -trim-exempt %q: %v", raw.trimExempt, err)
+		}
+		opts.TrimExempt = exempt
+	}
+
+	if raw.defaultCity {
+		if opts.DefaultForm == formatter.AirportFormCode {
+			return errors.New("-default-city cannot be combined with -default-airport-form code")
+		}
+		opts.DefaultForm = formatter.AirportFormCity
+	}
+	switch opts.DefaultForm {
+	case formatter.AirportFormName, formatter.AirportFormCity, formatter.AirportFormCode:
+	default:
+		return fmt.Errorf("Invalid -default-airport-form %q (expected name, city or code)", opts.DefaultForm)
+	}
+
+	if raw.now != "" {
+		now, err := formatter.ParseDateTime(raw.now)
+		if err != nil {
+			return fmt.Errorf("Invalid -now %q (expected a timestamp such as 2023-05-01T15:04Z)", raw.now)
+		}
+		opts.Now = now
+	}
+
+	switch opts.CoordinateFormat {
+	case formatter.CoordinatesRaw, formatter.CoordinatesDecimal, formatter.CoordinatesLatLon:
+	default:
+		return fmt.Errorf("Invalid -coordinate-format %q (expected raw, decimal or latlon)", opts.CoordinateFormat)
+	}
+	icaoMin, icaoMax, err := parseLengthRange(raw.icaoLength)
+	if err != nil {
+		return fmt.Errorf("Invalid -icao-length %q: %v", raw.icaoLength, err)
+	}
+	opts.ICAOMinLength, opts.ICAOMaxLength = icaoMin, icaoMax
+	opts.NameLanguage = strings.ToLower(strings.TrimSpace(opts.NameLanguage))
+
+	opts.Limits[formatter.TokenAirport] = raw.maxAirports
+	opts.Limits[formatter.TokenDate] = raw.maxDates
+	opts.Limits[formatter.TokenTime] = raw.maxTimes
+
+	if raw.handler != "" {
+		if raw.handlerPrefixes == "" {
+			return errors.New("-placeholder-handler needs -handler-prefixes")
+		}
+		prefixes, err := formatter.ParseHandlerPrefixes(raw.handlerPrefixes)
+		if err != nil {
+			return fmt.Errorf("Invalid -handler-prefixes: %v", err)
+		}
+		opts.HandlerCommand = strings.Fields(raw.handler)
+		opts.HandlerPrefixes = prefixes
+	}
+
+	chain, err := formatter.ParseFallbackChain(raw.fallbackChain)
+	if err != nil {
+		return fmt.Errorf("Invalid -fallback-chain: %v", err)
+	}
+	opts.FallbackChain = chain
+	return nil
+}
+
+// setOutput checks the flags that decide what is checked and written, and
+// the combinations of them that cannot work together.
+func (c *config) setOutput(raw *rawFlags) error {
+	if raw.onUnmappable != "error" && raw.onUnmappable != "replace" {
+		return fmt.Errorf("Invalid -on-unmappable %q (expected error or replace)", raw.onUnmappable)
+	}
+	c.replaceUnmappable = raw.onUnmappable == "replace"
+	if c.outputEncoding != "" {
+		if _, err := lookupEncoding(c.outputEncoding); err != nil {
+			return fmt.Errorf("Invalid -output-encoding: %v", err)
+		}
+	}
+	c.writeRetries = max(c.writeRetries, 0)
+
+	if raw.columns != "" {
+		columns, err := parseColumns(raw.columns)
+		if err != nil {
+			return fmt.Errorf("Invalid -columns: %v", err)
+		}
+		c.appendixColumns = columns
+	}
+	requirements, err := parseRequirements(raw.require)
+	if err != nil {
+		return fmt.Errorf("Invalid -require: %v", err)
+	}
+	c.requirements = requirements
+
+	if c.sideBySide && c.formats != "" {
+		return errors.New("-side-by-side cannot be combined with -formats")
+	}
+	// The output file is rendered as plain text unless -format picks a
+	// markup format.
+	switch c.format {
+	case "text":
+	case "html", "markdown":
+		switch {
+		case c.formats != "":
+			return fmt.Errorf("-format %s cannot be combined with -formats", c.format)
+		case c.base != "":
+			return fmt.Errorf("-format %s cannot be combined with -base", c.format)
+		case c.sideBySide:
+			return fmt.Errorf("-format %s cannot be combined with -side-by-side", c.format)
+		}
+		c.fileRenderer = c.renderer(c.format)
+	case "json":
+		switch {
+		case c.formats != "":
+			return errors.New("-format json cannot be combined with -formats")
+		case c.base != "":
+			return errors.New("-format json cannot be combined with -base")
+		}
+	default:
+		return fmt.Errorf("Invalid -format %q (expected text, html, markdown or json)", c.format)
+	}
+	if c.expect != "" && c.formats != "" {
+		return errors.New("-expect cannot be combined with -formats")
+	}
+	// Lines copied by -base are neither counted nor marked, so flags that
+	// need the placeholders of every line cannot see them.
+	if c.base != "" {
+		var conflict string
+		switch {
+		case c.strict:
+			conflict = "-strict"
+		case len(c.requirements) > 0:
+			conflict = "-require"
+		case c.appendix:
+			conflict = "-appendix"
+		case c.ics != "":
+			conflict = "-ics"
+		case c.legs:
+			conflict = "-legs"
+		case c.oneline:
+			conflict = "-oneline"
+		case c.jsonResult:
+			conflict = "-json-result"
+		}
+		if conflict != "" {
+			return errors.New(conflict + " cannot be combined with -base")
+		}
+	}
+	return nil
+}
+
+// setPaths resolves the input, output and airport lookup paths from the
+// positional arguments and -airports, for -lookup, -batch or a single
+// input.
+func (c *config) setPaths(raw *rawFlags, args []string) error {
+	if raw.delimiter != "" {
+		delimiter, err := parseDelimiter(raw.delimiter)
+		if err != nil {
+			return fmt.Errorf("Invalid -delimiter %q: %v", raw.delimiter, err)
+		}
+		c.airportDelimiter = delimiter
+	}
+	// A dry run writes no files, not even the airport cache.
+	c.useAirportCache = !raw.noCache && !c.dryRun
+
+	switch {
+	case c.lookup != "":
+		if len(args) > 0 {
+			return errors.New("-lookup takes no arguments; give the airport lookup file with -airports")
+		}
+		c.airportLookupPath = raw.airports
+	case c.batch:
+		switch {
+		case c.jsonResult:
+			return errors.New("-json-result cannot be combined with -batch")
+		case c.expect != "":
+			return errors.New("-expect cannot be combined with -batch")
+		case c.base != "":
+			return errors.New("-base cannot be combined with -batch")
+		case c.ics != "":
+			return errors.New("-ics cannot be combined with -batch")
+		case slices.Contains(args, "-"):
+			return errors.New("-batch cannot read the input from stdin")
+		}
+		c.inputPaths = expandInputPatterns(args)
+		c.airportLookupPath = raw.airports
+	default:
+		inputPath, outputPath, airportLookupPath, err := resolvePaths(args)
+		if err != nil {
+			return usageError{err}
+		}
+		if airportLookupPath != "" && raw.airports != "" {
+			return errors.New("give the airport lookup file as an argument or with -airports, not both")
+		}
+		c.airportLookupPath = cmp.Or(airportLookupPath, raw.airports)
+		c.inputPaths = []string{inputPath}
+		if outputPath != "-" {
+			c.outputPath = outputPath
+		}
+		if c.outputPath == "" {
+			switch {
+			case c.formats != "":
+				return errors.New("-formats needs an output file to name its files after")
+			case c.base != "":
+				return errors.New("-base needs an output file to reuse")
+			}
+		}
+	}
+	return nil
+}
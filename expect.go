@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells caps the size of the table lineDiff builds, in lines of
+// expected times lines of actual, after the common prefix and suffix are
+// left out. Larger differences report only their first differing line.
+const maxDiffCells = 4 << 20
+
+// lineDiff returns the lines that differ between expected and actual, each
+// prefixed with its line number and "-" for expected or "+" for actual
+// lines, or "" if they are equal. Lines common to both, found by longest
+// common subsequence, are left out.
+func lineDiff(expected, actual string) string {
+	if expected == actual {
+		return ""
+	}
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	// Lines shared at the start and end need no table.
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+	a = a[start : len(a)-end]
+	b = b[start : len(b)-end]
+
+	var diff strings.Builder
+	if len(a)*len(b) > maxDiffCells {
+		fmt.Fprintf(&diff, "%d: - %s\n", start+1, a[0])
+		fmt.Fprintf(&diff, "%d: + %s\n", start+1, b[0])
+		diff.WriteString("(too many differences to list; only the first differing line is shown)\n")
+		return diff.String()
+	}
+
+	// common[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+			fmt.Fprintf(&diff, "%d: - %s\n", start+i+1, a[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "%d: + %s\n", start+j+1, b[j])
+			j++
+		}
+	}
+	return diff.String()
+}
@@ -0,0 +1,696 @@
+package formatter
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Airport represents details of an airport.
+type Airport struct {
+	Name         string `json:"name"`
+	DisplayName  string `json:"display_name,omitempty"` // optional shorter name
+	ISOCountry   string `json:"iso_country"`
+	Municipality string `json:"municipality"` // city name
+	ICAOCode     string `json:"icao_code"`
+	IATACode     string `json:"iata_code"`
+	Coordinates  string `json:"coordinates"`
+
+	// Names holds localized names by language code, from name_xx columns.
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// parseCoordinates parses the coordinates column, stored as "longitude,
+// latitude" in the OurAirports data, and returns latitude and longitude.
+func parseCoordinates(coordinates string) (lat, lon float64, err error) {
+	lonStr, latStr, found := strings.Cut(coordinates, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("malformed coordinates %q", coordinates)
+	}
+	if lon, err = strconv.ParseFloat(strings.TrimSpace(lonStr), 64); err != nil {
+		return 0, 0, err
+	}
+	if lat, err = strconv.ParseFloat(strings.TrimSpace(latStr), 64); err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// AirportConflict records an airport code that appeared on more than one row
+// of the airport data.
+type AirportConflict struct {
+	Code    string
+	Kept    *Airport // the first row with the code, which is used for lookups
+	Ignored *Airport // a later row with the same code
+}
+
+// Conflicts returns the duplicate codes found in the airport data, in the
+// order they were encountered.
+func (f *Formatter) Conflicts() []AirportConflict {
+	return f.conflicts
+}
+
+// Lookup returns the airport with an IATA or ICAO code.
+func (f *Formatter) Lookup(code string) (*Airport, bool) {
+	airport, exists := f.airports[code]
+	return airport, exists
+}
+
+// Len returns the number of airport codes the Formatter can resolve.
+func (f *Formatter) Len() int {
+	return len(f.airports)
+}
+
+// AddAirports inserts airports under each of their codes. An airport
+// already loaded for one of those codes is replaced under all of its codes,
+// so overriding LAX also overrides ##KLAX. It must not be called while f is
+// formatting on another goroutine.
+func (f *Formatter) AddAirports(airports []*Airport) {
+	if f.airports == nil {
+		f.airports = make(map[string]*Airport)
+	}
+	for _, airport := range airports {
+		for _, code := range []string{airport.IATACode, airport.ICAOCode} {
+			if code == "" {
+				continue
+			}
+			if replaced, exists := f.airports[code]; exists {
+				for _, other := range []string{replaced.IATACode, replaced.ICAOCode} {
+					if other != "" && f.airports[other] == replaced {
+						f.airports[other] = airport
+					}
+				}
+			}
+			f.airports[code] = airport
+		}
+	}
+}
+
+// optionalColumn returns the trimmed value of a column that may be absent
+// from the airport data, or "" when the header lacks it.
+func optionalColumn(record []string, columnMap map[string]int, column string) string {
+	i, exists := columnMap[column]
+	if !exists {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// ReadAirportsCSV reads airports from CSV data.
+// It supports non-standard CSV column order by using header names.
+func ReadAirportsCSV(r io.Reader) ([]*Airport, error) {
+	return ReadAirportsDelimited(r, ',')
+}
+
+// utf8BOM is the byte order mark some editors write at the start of a file.
+const utf8BOM = "\xEF\xBB\xBF"
+
+// ReadAirportsDelimited reads airports like ReadAirportsCSV from data whose
+// fields are separated by delimiter, such as '\t' for TSV. A UTF-8 byte order
+// mark before the header is skipped. With a delimiter other than a comma,
+// quotes are not special inside a field, since TSV and similar formats
+// rarely quote fields and names may contain a bare quote.
+func ReadAirportsDelimited(r io.Reader, delimiter rune) ([]*Airport, error) {
+	buffered := bufio.NewReader(r)
+	if prefix, err := buffered.Peek(len(utf8BOM)); err == nil && string(prefix) == utf8BOM {
+		buffered.Discard(len(utf8BOM))
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = delimiter
+	reader.LazyQuotes = delimiter != ','
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build a map from trimmed, lowercased header name to index.
+	requiredColumns := []string{"name", "iso_country", "municipality", "icao_code", "iata_code", "coordinates"}
+	columnMap := make(map[string]int)
+	for i, column := range header {
+		key := strings.TrimSpace(strings.ToLower(column))
+		columnMap[key] = i
+	}
+	// Debug: print the header mapping (remove if not needed)
+	// fmt.Printf("Header mapping: %#v\n", columnMap)
+
+	// Ensure all required columns exist.
+	for _, req := range requiredColumns {
+		if _, exists := columnMap[req]; !exists {
+			return nil, fmt.Errorf("missing required column: %s", req)
+		}
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// Localized names come from name_xx columns, keyed by language.
+	nameColumns := make(map[string]int)
+	for column, i := range columnMap {
+		if language, found := strings.CutPrefix(column, "name_"); found && language != "" {
+			nameColumns[language] = i
+		}
+	}
+
+	var airports []*Airport
+	for _, record := range records {
+		// Skip empty records.
+		if len(record) == 0 {
+			continue
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("malformed record")
+		}
+		var names map[string]string
+		for language, i := range nameColumns {
+			if name := strings.TrimSpace(record[i]); name != "" {
+				if names == nil {
+					names = make(map[string]string)
+				}
+				names[language] = name
+			}
+		}
+		airports = append(airports, &Airport{
+			Names:        names,
+			Name:         record[columnMap["name"]],
+			DisplayName:  optionalColumn(record, columnMap, "display_name"),
+			ISOCountry:   record[columnMap["iso_country"]],
+			Municipality: record[columnMap["municipality"]],
+			ICAOCode:     record[columnMap["icao_code"]],
+			IATACode:     record[columnMap["iata_code"]],
+			Coordinates:  record[columnMap["coordinates"]],
+		})
+	}
+	return airports, nil
+}
+
+// ReadAirportsJSON reads airports from a JSON array of objects with the same
+// fields as the CSV columns.
+func ReadAirportsJSON(r io.Reader) ([]*Airport, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var airports []*Airport
+	if err := json.Unmarshal(data, &airports); err != nil {
+		return nil, err
+	}
+	for i, airport := range airports {
+		if airport == nil {
+			return nil, fmt.Errorf("airport %d is null", i+1)
+		}
+	}
+	return airports, nil
+}
+
+// indexAirports validates airports and stores them under both their IATA
+// and ICAO codes.
+func (f *Formatter) indexAirports(airports []*Airport) error {
+	index := make(map[string]*Airport)
+	var conflicts []AirportConflict
+	for _, airport := range airports {
+		if strings.TrimSpace(airport.Name) == "" {
+			return fmt.Errorf("empty name in record")
+		}
+		if strings.TrimSpace(airport.IATACode) == "" && strings.TrimSpace(airport.ICAOCode) == "" {
+			return fmt.Errorf("record has no IATA or ICAO code")
+		}
+
+		// When a code appears on more than one record the first record wins
+		// and the conflict is recorded.
+		for _, code := range []string{airport.IATACode, airport.ICAOCode} {
+			if code == "" {
+				continue
+			}
+			if kept, exists := index[code]; exists {
+				if kept != airport {
+					conflicts = append(conflicts, AirportConflict{Code: code, Kept: kept, Ignored: airport})
+				}
+				continue
+			}
+			index[code] = airport
+		}
+	}
+
+	f.airports = index
+	f.conflicts = conflicts
+	return nil
+}
+
+// codeTokens matches airport code tokens in any letter case: lists, ICAO
+// codes and IATA codes. A code must end at a word boundary so that words
+// such as "#hashtag" are not mistaken for codes.
+var codeTokens = regexp.MustCompile(`(\*?)(?:#\[\s*([A-Za-z]{3,4}(?:\s*,\s*[A-Za-z]{3,4})*)\s*\]|##([A-Za-z]{4})\b|#([A-Za-z]{3})\b)`)
+
+// normalizeCodeTokens rewrites every airport code token in uppercase with the
+// whitespace inside lists removed, e.g. "*#[ lax , kjfk ]" becomes
+// "*#[LAX,KJFK]" and "##egll" becomes "##EGLL". Codes are not looked up.
+func normalizeCodeTokens(content string) string {
+	return codeTokens.ReplaceAllStringFunc(content, func(match string) string {
+		groups := codeTokens.FindStringSubmatch(match)
+		switch {
+		case groups[2] != "":
+			codes := strings.Split(groups[2], ",")
+			for i, code := range codes {
+				codes[i] = strings.ToUpper(strings.TrimSpace(code))
+			}
+			return groups[1] + "#[" + strings.Join(codes, ",") + "]"
+		case groups[3] != "":
+			return groups[1] + "##" + strings.ToUpper(groups[3])
+		default:
+			return groups[1] + "#" + strings.ToUpper(groups[4])
+		}
+	})
+}
+
+// normalizeAirportName folds the case and runs of whitespace in an airport
+// name so that names can be compared as written in running text.
+func normalizeAirportName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// airportNameCodes maps the normalized name and display name of every airport
+// to its code token, e.g. "los angeles international airport" to "#LAX".
+// Airports without an IATA code use their ICAO code. Names shared by airports
+// with different codes are left out, as they cannot be reversed.
+func (f *Formatter) airportNameCodes() map[string]string {
+	codes := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for _, airport := range f.airports {
+		token := "#" + airport.IATACode
+		if airport.IATACode == "" {
+			token = "##" + airport.ICAOCode
+		}
+		for _, name := range []string{airport.Name, airport.DisplayName} {
+			key := normalizeAirportName(name)
+			if key == "" {
+				continue
+			}
+			if existing, exists := codes[key]; exists && existing != token {
+				ambiguous[key] = true
+			}
+			codes[key] = token
+		}
+	}
+	for key := range ambiguous {
+		delete(codes, key)
+	}
+	return codes
+}
+
+// reverseAirportNames replaces every known airport name in content with its
+// code token, e.g. "Los Angeles International Airport" becomes "#LAX". Names
+// match in any letter case and with any whitespace between words. Where
+// names overlap, as in "Paris Orly" and "Paris Orly Airport", the longest
+// one wins.
+func (f *Formatter) reverseAirportNames(content string) string {
+	codes := f.airportNameCodes()
+	if len(codes) == 0 {
+		return content
+	}
+	names := make([]string, 0, len(codes))
+	for name := range codes {
+		names = append(names, name)
+	}
+	// Go regexps prefer the earliest alternative, so longer names go first.
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	for i, name := range names {
+		words := strings.Fields(name)
+		for j, word := range words {
+			words[j] = regexp.QuoteMeta(word)
+		}
+		names[i] = strings.Join(words, `\s+`)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(names, "|") + `)\b`)
+	return pattern.ReplaceAllStringFunc(content, func(match string) string {
+		return codes[normalizeAirportName(match)]
+	})
+}
+
+// expandICAO expands an ICAO airport code token (*##ABCD or ##ABCD*). A
+// code longer than ICAOMaxLength is left verbatim.
+func (f *Formatter) expandICAO(groups []string, counter *Counter) string {
+	if len(groups[2]) > f.ICAOMaxLength {
+		return groups[0]
+	}
+	return f.expandAirport(groups[0], groups[1] == "*" || groups[3] == "*", groups[2], "ICAO", counter)
+}
+
+// expandIATA expands an IATA airport code token (*#ABC or #ABC*). A code of
+// more than three letters, such as an ICAO code written with one "#", is
+// left verbatim.
+func (f *Formatter) expandIATA(groups []string, counter *Counter) string {
+	if groups[2] == "#" || len(groups[3]) != 3 {
+		return groups[0]
+	}
+	return f.expandAirport(groups[0], groups[1] == "*" || groups[4] == "*", groups[3], "IATA", counter)
+}
+
+// expandAirport replaces an airport code token with the airport in its
+// default form, or its alternate form when starred. Codes are looked up in
+// uppercase, so "#lax" resolves like "#LAX". Unknown codes are left as-is;
+// they only count as unresolved when written in uppercase, or when
+// NormalizeUnresolvedCase is set, so that words such as "#hashtag" are not
+// reported. form names the code notation ("IATA" or "ICAO") used by the
+// token.
+func (f *Formatter) expandAirport(match string, starred bool, code, form string, counter *Counter) string {
+	// Without airport data every lookup would silently miss.
+	if len(f.airports) == 0 {
+		counter.missingAirportData = true
+		return match
+	}
+	written := code
+	code = strings.ToUpper(code)
+	airport, exists := f.airports[code]
+	if !exists {
+		if written != code && !f.NormalizeUnresolvedCase {
+			return match
+		}
+		counter.recordUnresolved(code)
+		return f.unresolvedAirport(match)
+	}
+	if !counter.allow(TokenAirport, f.Limits[TokenAirport]) {
+		return match
+	}
+	counter.recordReferenced(airport)
+	expansion := f.airportForm(airport, code, starred, counter)
+	if f.ShowMatchedCode {
+		expansion = fmt.Sprintf("%s (via %s)", expansion, form)
+	}
+	return f.annotateAirport(match, expansion)
+}
+
+// Airport forms selectable with Options.DefaultForm.
+const (
+	AirportFormName = "name"
+	AirportFormCity = "city"
+	AirportFormCode = "code"
+)
+
+// airportForm returns the marked airport in the default form, or in the
+// alternate form when starred. When that form is empty, the forms after it
+// in FallbackChain are tried, and the name is the last resort.
+func (f *Formatter) airportForm(airport *Airport, code string, starred bool, counter *Counter) string {
+	form := f.DefaultForm
+	if starred {
+		form = AirportFormCity
+		if f.DefaultForm == AirportFormCity {
+			form = AirportFormName
+		}
+	}
+	if form == AirportFormCity && strings.TrimSpace(airport.Municipality) == "" {
+		counter.recordIncomplete(code, "municipality")
+	}
+
+	forms := []string{form}
+	if i := slices.Index(f.FallbackChain, form); i >= 0 {
+		forms = append(forms, f.FallbackChain[i+1:]...)
+	} else {
+		forms = append(forms, f.FallbackChain...)
+	}
+	for _, candidate := range forms {
+		if marked := f.airportFormValue(airport, code, candidate); marked != "" {
+			return marked
+		}
+	}
+	return f.airportName(airport, code)
+}
+
+// airportFormValue returns the marked airport in one form, or "" when the
+// airport has no value for it.
+func (f *Formatter) airportFormValue(airport *Airport, code, form string) string {
+	switch form {
+	case AirportFormCity:
+		if strings.TrimSpace(airport.Municipality) == "" {
+			return ""
+		}
+		city := airport.Municipality
+		if f.CityCountrySeparator != "" && airport.ISOCountry != "" {
+			city += f.CityCountrySeparator + airport.ISOCountry
+		}
+		return markCode(ValueCity, code, city)
+	case AirportFormCode:
+		return markCode(ValueAirport, code, code)
+	}
+	return f.airportName(airport, code)
+}
+
+// ParseFallbackChain parses a comma-separated list of airport forms for
+// Options.FallbackChain.
+func ParseFallbackChain(list string) ([]string, error) {
+	var chain []string
+	for _, form := range strings.Split(list, ",") {
+		form = strings.ToLower(strings.TrimSpace(form))
+		switch form {
+		case AirportFormName, AirportFormCity, AirportFormCode:
+			chain = append(chain, form)
+		default:
+			return nil, fmt.Errorf("unknown form %q (expected name, city or code)", form)
+		}
+	}
+	return chain, nil
+}
+
+// expandAirportList expands a list of airport codes (*#[LAX,SFO,JFK]) into
+// the joined airports in their default form, or alternate form with the "*"
+// prefix. Members that cannot be resolved are kept as their raw code.
+func (f *Formatter) expandAirportList(groups []string, counter *Counter) string {
+	if len(f.airports) == 0 {
+		counter.missingAirportData = true
+		return groups[0]
+	}
+	var expansions []string
+	for _, raw := range strings.Split(groups[2], ",") {
+		raw = strings.TrimSpace(raw)
+		code := strings.ToUpper(raw)
+		airport, exists := f.airports[code]
+		if !exists {
+			counter.recordUnresolved(code)
+			expansions = append(expansions, raw)
+			continue
+		}
+		if !counter.allow(TokenAirport, f.Limits[TokenAirport]) {
+			expansions = append(expansions, raw)
+			continue
+		}
+		counter.recordReferenced(airport)
+		expansions = append(expansions, f.airportForm(airport, code, groups[1] == "*" || groups[3] == "*", counter))
+	}
+	return f.annotateAirport(groups[0], strings.Join(expansions, f.ListSeparator))
+}
+
+// expandMapLink expands a #mapABC placeholder into a maps URL built from the
+// airport's coordinates. Unknown codes and unparseable coordinates leave the
+// placeholder as-is.
+func (f *Formatter) expandMapLink(groups []string, counter *Counter) string {
+	if len(f.airports) == 0 {
+		counter.missingAirportData = true
+		return groups[0]
+	}
+	airport, exists := f.airports[groups[1]]
+	if !exists {
+		counter.recordUnresolved(groups[1])
+		return groups[0]
+	}
+	lat, lon, err := parseCoordinates(airport.Coordinates)
+	if err != nil {
+		counter.recordIncomplete(groups[1], "coordinates")
+		return groups[0]
+	}
+	if !counter.allow(TokenAirport, f.Limits[TokenAirport]) {
+		return groups[0]
+	}
+	counter.recordReferenced(airport)
+	link := strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', -1, 64),
+	).Replace(f.MapURLTemplate)
+	return markCode(ValueMapLink, groups[1], link)
+}
+
+// Coordinate formats for C(#ABC) placeholders.
+const (
+	CoordinatesRaw     = "raw"     // as stored, e.g. "-118.408, 33.9425"
+	CoordinatesDecimal = "decimal" // rounded, with hemispheres, e.g. "33.9425°N, 118.4080°W"
+	CoordinatesLatLon  = "latlon"  // latitude first, e.g. "33.9425, -118.408"
+)
+
+// expandCoordinates expands a C(#ABC) or C(##ABCD) placeholder to the
+// airport's coordinates in CoordinateFormat. An airport without usable
+// coordinates leaves the placeholder as written, like an unknown code.
+func (f *Formatter) expandCoordinates(groups []string, counter *Counter) string {
+	if len(f.airports) == 0 {
+		counter.missingAirportData = true
+		return groups[0]
+	}
+	code := groups[1] + groups[2]
+	airport, exists := f.airports[code]
+	if !exists {
+		counter.recordUnresolved(code)
+		return groups[0]
+	}
+	coordinates, err := formatCoordinates(airport.Coordinates, f.CoordinateFormat)
+	if err != nil {
+		counter.recordIncomplete(code, "coordinates")
+		return groups[0]
+	}
+	if !counter.allow(TokenAirport, f.Limits[TokenAirport]) {
+		return groups[0]
+	}
+	counter.recordReferenced(airport)
+	return markCode(ValueCoordinates, code, coordinates)
+}
+
+// expandCountry expands a CTRY(#ABC) or CTRY(##ABCD) placeholder to the
+// name of the airport's country, or to its ISO code when the code is not in
+// the country table. An airport without a country leaves the placeholder as
+// written.
+func (f *Formatter) expandCountry(groups []string, counter *Counter) string {
+	if len(f.airports) == 0 {
+		counter.missingAirportData = true
+		return groups[0]
+	}
+	code := groups[1] + groups[2]
+	airport, exists := f.airports[code]
+	if !exists {
+		counter.recordUnresolved(code)
+		return groups[0]
+	}
+	country := strings.ToUpper(strings.TrimSpace(airport.ISOCountry))
+	if country == "" {
+		counter.recordIncomplete(code, "iso_country")
+		return groups[0]
+	}
+	if !counter.allow(TokenAirport, f.Limits[TokenAirport]) {
+		return groups[0]
+	}
+	counter.recordReferenced(airport)
+	return markCode(ValueCountry, code, CountryName(country))
+}
+
+// formatCoordinates formats a coordinates column value in one of the
+// Coordinates formats.
+func formatCoordinates(coordinates, format string) (string, error) {
+	if format == CoordinatesRaw {
+		if strings.TrimSpace(coordinates) == "" {
+			return "", fmt.Errorf("empty coordinates")
+		}
+		return strings.TrimSpace(coordinates), nil
+	}
+	lat, lon, err := parseCoordinates(coordinates)
+	if err != nil {
+		return "", err
+	}
+	if format == CoordinatesLatLon {
+		return strconv.FormatFloat(lat, 'f', -1, 64) + ", " + strconv.FormatFloat(lon, 'f', -1, 64), nil
+	}
+	return hemisphere(lat, "N", "S") + ", " + hemisphere(lon, "E", "W"), nil
+}
+
+// hemisphere writes a degree value rounded to four decimals, about 11 m,
+// with the hemisphere letter for its sign.
+func hemisphere(degrees float64, positive, negative string) string {
+	letter := positive
+	if degrees < 0 {
+		letter, degrees = negative, -degrees
+	}
+	return strconv.FormatFloat(degrees, 'f', 4, 64) + "°" + letter
+}
+
+// annotateAirport returns the expansion for a matched airport token, keeping
+// the token itself when annotation is enabled.
+func (f *Formatter) annotateAirport(match, expansion string) string {
+	if f.Annotate {
+		return fmt.Sprintf("%s [%s]", match, expansion)
+	}
+	return expansion
+}
+
+// unresolvedAirport returns the output for an airport token that did not
+// resolve to a known airport.
+func (f *Formatter) unresolvedAirport(match string) string {
+	if f.NormalizeUnresolvedCase {
+		return strings.ToUpper(match)
+	}
+	return match
+}
+
+// airportName returns the marked airport name for the code it was looked up
+// by. A localized name in NameLanguage comes first, then the display name
+// when preferred, then the name.
+func (f *Formatter) airportName(airport *Airport, code string) string {
+	if name := airport.Names[f.NameLanguage]; f.NameLanguage != "" && name != "" {
+		return markCode(ValueAirport, code, name)
+	}
+	if f.PreferDisplayName && airport.DisplayName != "" {
+		return markCode(ValueAirport, code, airport.DisplayName)
+	}
+	return markCode(ValueAirport, code, airport.Name)
+}
+
+// maxSuggestions caps how many known codes SuggestCodes returns.
+const maxSuggestions = 3
+
+// SuggestCodes returns up to maxSuggestions known codes of the same length
+// as code within an edit distance of 2, closest first, then in code order,
+// e.g. "LAX" for "LAS".
+func (f *Formatter) SuggestCodes(code string) []string {
+	type candidate struct {
+		code     string
+		distance int
+	}
+	var candidates []candidate
+	for known := range f.airports {
+		if len(known) != len(code) || known == code {
+			continue
+		}
+		if distance := editDistance(code, known); distance <= 2 {
+			candidates = append(candidates, candidate{known, distance})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].code < candidates[j].code
+	})
+	var codes []string
+	for _, c := range candidates[:min(len(candidates), maxSuggestions)] {
+		codes = append(codes, c.code)
+	}
+	return codes
+}
+
+// editDistance returns the Levenshtein distance between two ASCII strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,733 @@
+package formatter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Annotate = true
+	checkFormat(t, f, map[string]string{
+		"#LAX":         "#LAX [Los Angeles International Airport]",
+		"##EGLL":       "##EGLL [London Heathrow Airport]",
+		"*#CDG":        "*#CDG [Paris]",
+		"#ZZZ":         "#ZZZ",
+		"#LAX to #ZZZ": "#LAX [Los Angeles International Airport] to #ZZZ",
+	})
+}
+
+func TestProcessWithoutAirportData(t *testing.T) {
+	f := &Formatter{Options: DefaultOptions()}
+	got, counter := process(f, "#LAX ##EGLL D(2023-05-01T10:00Z)")
+	if want := "#LAX ##EGLL 01 May 2023"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if warnings := f.Warnings(counter); !slices.Contains(warnings, "no airport data loaded; airport codes left unexpanded") {
+		t.Errorf("Warnings = %q, want the missing airport data reported", warnings)
+	}
+
+	// A lookup file with only its header is reported the same way.
+	header, _, _ := strings.Cut(testAirportsCSV, "\n")
+	f, err := New(strings.NewReader(header + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range []string{"#LAX", "#[LAX,JFK]", "#mapLAX", "C(#LAX)", "CTRY(#LAX)"} {
+		got, counter := process(f, input)
+		if got != input {
+			t.Errorf("output = %q, want %q", got, input)
+		}
+		if warnings := f.Warnings(counter); !slices.Contains(warnings, "no airport data loaded; airport codes left unexpanded") {
+			t.Errorf("%s: Warnings = %q, want the empty airport data reported", input, warnings)
+		}
+	}
+}
+
+func TestNormalizeUnresolvedCase(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#zzz":  "#zzz",
+		"#ZZZ":  "#ZZZ",
+		"*#Qqq": "*#Qqq",
+	})
+
+	f.NormalizeUnresolvedCase = true
+	checkFormat(t, f, map[string]string{
+		"#zzz":          "#ZZZ",
+		"*#Qqq":         "*#QQQ",
+		"##zzzz":        "##ZZZZ",
+		"#LAX and #zzz": "Los Angeles International Airport and #ZZZ",
+	})
+}
+
+func TestShowMatchedCode(t *testing.T) {
+	f := newTestFormatter(t)
+	f.ShowMatchedCode = true
+	checkFormat(t, f, map[string]string{
+		"#LAX":    "Los Angeles International Airport (via IATA)",
+		"##KLAX":  "Los Angeles International Airport (via ICAO)",
+		"*#CDG":   "Paris (via IATA)",
+		"*##LFPG": "Paris (via ICAO)",
+		"#ZZZ":    "#ZZZ",
+	})
+}
+
+func TestAirportList(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#[LAX,JFK,EGLL]":     "Los Angeles International Airport, John F Kennedy International Airport, London Heathrow Airport",
+		"*#[LAX, CDG]":        "Los Angeles, Paris",
+		"#[ LAX , LFPG ]":     "Los Angeles International Airport, Charles de Gaulle International Airport",
+		"#[LAX,ZZZ,JFK]":      "Los Angeles International Airport, ZZZ, John F Kennedy International Airport",
+		"#[QQQ,ZZZZ]":         "QQQ, ZZZZ",
+		"Route: *#[LAX,CDG].": "Route: Los Angeles, Paris.",
+	})
+	_, counter := process(f, "#[LAX,ZZZ,JFK]")
+	if got := counter.UnresolvedCodes(); !slices.Equal(got, []string{"ZZZ"}) {
+		t.Errorf("UnresolvedCodes = %q, want [ZZZ]", got)
+	}
+
+	f.ListSeparator = " → "
+	checkFormat(t, f, map[string]string{
+		"*#[LAX,JFK,CDG]": "Los Angeles → New York → Paris",
+	})
+}
+
+func TestMapLink(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#mapLAX":          "https://maps.google.com/?q=33.9425,-118.408",
+		"#mapLFPG":         "https://maps.google.com/?q=49.012779,2.55",
+		"See #mapJFK.":     "See https://maps.google.com/?q=40.6398,-73.7789.",
+		"#mapZZZ":          "#mapZZZ",
+		"#mapLAX and #LAX": "https://maps.google.com/?q=33.9425,-118.408 and Los Angeles International Airport",
+	})
+
+	f.MapURLTemplate = "https://www.openstreetmap.org/?mlat={lat}&mlon={lon}"
+	checkFormat(t, f, map[string]string{
+		"#mapEGLL": "https://www.openstreetmap.org/?mlat=51.4706&mlon=-0.461941",
+	})
+}
+
+func TestCoordinates(t *testing.T) {
+	f, err := New(strings.NewReader(testAirportsCSV + "Nowhere Airport,US,Nowhere,KNOW,NOW,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFormat(t, f, map[string]string{
+		"C(#LAX)":        "-118.408, 33.9425",
+		"C(##LFPG) away": "2.55, 49.012779 away",
+		"C(#ZZZ)":        "C(#ZZZ)",
+		"C(#NOW)":        "C(#NOW)",
+		"ABC(#LAX)":      "ABC(Los Angeles International Airport)",
+	})
+
+	f.CoordinateFormat = CoordinatesDecimal
+	checkFormat(t, f, map[string]string{
+		"C(#LAX)":   "33.9425°N, 118.4080°W",
+		"C(##LFPG)": "49.0128°N, 2.5500°E",
+	})
+	f.CoordinateFormat = CoordinatesLatLon
+	checkFormat(t, f, map[string]string{
+		"C(#LAX)": "33.9425, -118.408",
+	})
+
+	f.Verbose = true
+	_, counter := process(f, "C(#NOW)")
+	if got, want := f.Warnings(counter), []string{"#NOW resolved but has empty coordinates"}; !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+}
+
+func TestCountry(t *testing.T) {
+	f, err := New(strings.NewReader(testAirportsCSV +
+		"Pristina International Airport,xk,Pristina,BKPR,PRN,\n" +
+		"Nowhere Airport,QZ,Nowhere,KNOW,NOW,\n" +
+		"Stateless Airport,,Nowhere,KSTL,STL,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFormat(t, f, map[string]string{
+		"CTRY(#CDG)":       "France",
+		"CTRY(##EGLL), UK": "United Kingdom, UK",
+		"CTRY(#PRN)":       "Kosovo",
+		"CTRY(#NOW)":       "QZ",
+		"CTRY(#STL)":       "CTRY(#STL)",
+		"CTRY(#ZZZ)":       "CTRY(#ZZZ)",
+		"#LAX, CTRY(#LAX)": "Los Angeles International Airport, United States",
+		"XCTRY(#LAX)":      "XCTRY(Los Angeles International Airport)",
+	})
+
+	f.Verbose = true
+	_, counter := process(f, "CTRY(#STL)")
+	if got, want := f.Warnings(counter), []string{"#STL resolved but has empty iso_country"}; !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	lat, lon, err := parseCoordinates("-118.408, 33.9425")
+	if err != nil || lat != 33.9425 || lon != -118.408 {
+		t.Errorf("parseCoordinates = %v, %v, %v; want 33.9425, -118.408", lat, lon, err)
+	}
+	for _, coordinates := range []string{"", "unknown", "1.5, north"} {
+		if _, _, err := parseCoordinates(coordinates); err == nil {
+			t.Errorf("parseCoordinates(%q) succeeded", coordinates)
+		}
+	}
+}
+
+func TestDuplicateCodes(t *testing.T) {
+	f, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425"
+Los Angeles Heliport,US,Los Angeles,KLAX,,"-118.4, 33.9"
+Charles de Gaulle International Airport,FR,Paris,LFPG,CDG,"2.55, 49.012779"
+Paris Le Bourget Airport,FR,Paris,LFPB,CDG,"2.44, 48.96"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first row with a code wins.
+	checkFormat(t, f, map[string]string{
+		"##KLAX": "Los Angeles International Airport",
+		"#CDG":   "Charles de Gaulle International Airport",
+		"##LFPB": "Paris Le Bourget Airport",
+	})
+
+	var got []string
+	for _, conflict := range f.Conflicts() {
+		got = append(got, conflict.Code+": "+conflict.Kept.Name+" over "+conflict.Ignored.Name)
+	}
+	want := []string{
+		"KLAX: Los Angeles International Airport over Los Angeles Heliport",
+		"CDG: Charles de Gaulle International Airport over Paris Le Bourget Airport",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Conflicts = %q, want %q", got, want)
+	}
+
+	// With Verbose, the conflicts are among the warnings.
+	f.Verbose = true
+	want = []string{
+		`duplicate code ##KLAX in the airport data: using "Los Angeles International Airport", ignoring "Los Angeles Heliport"`,
+		`duplicate code #CDG in the airport data: using "Charles de Gaulle International Airport", ignoring "Paris Le Bourget Airport"`,
+	}
+	if got := f.Warnings(NewCounter()); !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+	f.Verbose = false
+	if got := f.Warnings(NewCounter()); len(got) != 0 {
+		t.Errorf("Warnings without Verbose = %q, want none", got)
+	}
+
+	// Other data has conflicts of its own.
+	if conflicts := newTestFormatter(t).Conflicts(); len(conflicts) != 0 {
+		t.Errorf("Conflicts of other data = %v, want none", conflicts)
+	}
+}
+
+func TestPreferDisplayName(t *testing.T) {
+	f, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates,display_name
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425",LAX Airport
+Charles de Gaulle International Airport,FR,Paris,LFPG,CDG,"2.55, 49.012779",
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFormat(t, f, map[string]string{
+		"#LAX": "Los Angeles International Airport",
+	})
+
+	f.PreferDisplayName = true
+	checkFormat(t, f, map[string]string{
+		"#LAX":   "LAX Airport",
+		"##KLAX": "LAX Airport",
+		"*#LAX":  "Los Angeles",
+		"#CDG":   "Charles de Gaulle International Airport",
+	})
+
+	// Without a display_name column the option changes nothing.
+	f = newTestFormatter(t)
+	f.PreferDisplayName = true
+	checkFormat(t, f, map[string]string{
+		"#LAX": "Los Angeles International Airport",
+	})
+}
+
+func TestStrictCodeBoundary(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#LAX2":  "Los Angeles International Airport2",
+		"#LAX-2": "Los Angeles International Airport-2",
+		"#LAX 2": "Los Angeles International Airport 2",
+	})
+
+	f.StrictCodeBoundary = true
+	checkFormat(t, f, map[string]string{
+		"#LAX2":      "#LAX2",
+		"##EGLL9":    "##EGLL9",
+		"#LAX-2":     "Los Angeles International Airport-2",
+		"#LAX 2":     "Los Angeles International Airport 2",
+		"#LAX.":      "Los Angeles International Airport.",
+		"*#CDG2":     "*#CDG2",
+		"#LAX2 #JFK": "#LAX2 John F Kennedy International Airport",
+	})
+}
+
+func TestDefaultAirportForm(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := map[string]map[string]string{
+		AirportFormName: {
+			"#LAX":    "Los Angeles International Airport",
+			"*#LAX":   "Los Angeles",
+			"*##EGLL": "London",
+		},
+		AirportFormCity: {
+			"#LAX":        "Los Angeles",
+			"*#LAX":       "Los Angeles International Airport",
+			"##EGLL":      "London",
+			"*##EGLL":     "London Heathrow Airport",
+			"#[LAX,CDG]":  "Los Angeles, Paris",
+			"*#[LAX,CDG]": "Los Angeles International Airport, Charles de Gaulle International Airport",
+		},
+		AirportFormCode: {
+			"#LAX":   "LAX",
+			"##EGLL": "EGLL",
+			"*#LAX":  "Los Angeles",
+			"#ZZZ":   "#ZZZ",
+		},
+	}
+	for form, cases := range tests {
+		t.Run(form, func(t *testing.T) {
+			f.DefaultForm = form
+			checkFormat(t, f, cases)
+		})
+	}
+}
+
+func TestTrailingStar(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#LAX*":  "Los Angeles International Airport*",
+		"*#LAX":  "Los Angeles",
+		"*#LAX*": "Los Angeles*",
+	})
+
+	f.TrailingStar = true
+	checkFormat(t, f, map[string]string{
+		"#LAX*":          "Los Angeles",
+		"##EGLL*":        "London",
+		"*#LAX":          "Los Angeles",
+		"*#LAX*":         "Los Angeles",
+		"#[LAX,CDG]*":    "Los Angeles, Paris",
+		"#LAX* and #JFK": "Los Angeles and John F Kennedy International Airport",
+		"#ZZZ*":          "#ZZZ*",
+	})
+}
+
+func TestReadAirportsDelimited(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter rune
+		data      string
+	}{
+		{"tab", '\t', "name\tiso_country\tmunicipality\ticao_code\tiata_code\tcoordinates\n" +
+			"Los Angeles International Airport\tUS\tLos Angeles\tKLAX\tLAX\t-118.408, 33.9425\n"},
+		{"pipe", '|', "name|iso_country|municipality|icao_code|iata_code|coordinates\n" +
+			"Los Angeles International Airport|US|Los Angeles|KLAX|LAX|-118.408, 33.9425\n"},
+		{"comma with BOM", ',', "\xEF\xBB\xBF" + testAirportsCSV},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			airports, err := ReadAirportsDelimited(strings.NewReader(tt.data), tt.delimiter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := NewFromAirports(airports)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFormat(t, f, map[string]string{
+				"#LAX":    "Los Angeles International Airport",
+				"*##KLAX": "Los Angeles",
+			})
+		})
+	}
+
+	// With another delimiter a bare quote inside a field is text.
+	airports, err := ReadAirportsDelimited(strings.NewReader("name\tiso_country\tmunicipality\ticao_code\tiata_code\tcoordinates\n"+
+		"O'Hare \"ORD\" Airport\tUS\tChicago\tKORD\tORD\t\n"), '\t')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := airports[0].Name, `O'Hare "ORD" Airport`; got != want {
+		t.Errorf("name with quotes = %q, want %q", got, want)
+	}
+	if _, err := ReadAirportsCSV(strings.NewReader("name,iso_country,municipality,icao_code,iata_code,coordinates\n" +
+		"O'Hare \"ORD\" Airport,US,Chicago,KORD,ORD,\n")); err == nil {
+		t.Error("ReadAirportsCSV accepted a bare quote")
+	}
+
+	// The wrong delimiter leaves the required columns missing.
+	if _, err := ReadAirportsDelimited(strings.NewReader(testAirportsCSV), '\t'); err == nil {
+		t.Error("ReadAirportsDelimited read comma-separated data as tab-separated")
+	}
+}
+
+func TestReadAirportsJSON(t *testing.T) {
+	airports, err := ReadAirportsJSON(strings.NewReader(`[
+		{"name": "Los Angeles International Airport", "iso_country": "US", "municipality": "Los Angeles",
+		 "icao_code": "KLAX", "iata_code": "LAX", "coordinates": "-118.408, 33.9425", "display_name": "LAX Airport"},
+		{"name": "Hamad International Airport", "iso_country": "QA", "municipality": "Doha",
+		 "icao_code": "OTHH", "iata_code": "DOH", "coordinates": "51.608, 25.273"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFromAirports(airports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFormat(t, f, map[string]string{
+		"#LAX":    "Los Angeles International Airport",
+		"*##OTHH": "Doha",
+		"#mapDOH": "https://maps.google.com/?q=25.273,51.608",
+	})
+	f.PreferDisplayName = true
+	checkFormat(t, f, map[string]string{
+		"#LAX": "LAX Airport",
+	})
+
+	for input, want := range map[string]string{
+		`{"name": "Los Angeles International Airport"}`: "cannot unmarshal object",
+		`[{"name": "Los Angeles"}, null]`:               "airport 2 is null",
+		`[{"name": "Los Angeles"`:                       "unexpected end of JSON input",
+	} {
+		if _, err := ReadAirportsJSON(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ReadAirportsJSON(%s) error = %v, want %s", input, err, want)
+		}
+	}
+}
+
+func TestNormalizeCodes(t *testing.T) {
+	f := newTestFormatter(t)
+	f.NormalizeCodes = true
+	checkFormat(t, f, map[string]string{
+		"#lax":                    "#LAX",
+		"*##egll":                 "*##EGLL",
+		"*#[ lax , kjfk ]":        "*#[LAX,KJFK]",
+		"#zzz and #Cdg":           "#ZZZ and #CDG",
+		"#hashtag":                "#hashtag",
+		"D(2023-05-01T10:00Z)":    "D(2023-05-01T10:00Z)",
+		"From #lax on #[jfk,lhr]": "From #LAX on #[JFK,LHR]",
+	})
+}
+
+func TestConsumeBrackets(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"[#LAX]": "[Los Angeles International Airport]",
+	})
+
+	f.ConsumeBrackets = true
+	checkFormat(t, f, map[string]string{
+		"[#LAX]":          "Los Angeles International Airport",
+		"[##EGLL]":        "London Heathrow Airport",
+		"[*#CDG]":         "Paris",
+		"Fly to [#JFK].":  "Fly to John F Kennedy International Airport.",
+		"[see #LAX here]": "[see Los Angeles International Airport here]",
+		"[#LAX, #JFK]":    "[Los Angeles International Airport, John F Kennedy International Airport]",
+		"[#ZZZ]":          "[#ZZZ]",
+	})
+}
+
+func TestNameLanguage(t *testing.T) {
+	f, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates,display_name,name_fr,Name_DE
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425",LAX Airport,Aéroport international de Los Angeles,Flughafen Los Angeles
+London Heathrow Airport,GB,London,EGLL,LHR,"-0.461941, 51.4706",Heathrow,,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if airport, _ := f.Lookup("LAX"); len(airport.Names) != 2 {
+		t.Errorf("Names = %q, want fr and de", airport.Names)
+	}
+
+	f.NameLanguage = "fr"
+	checkFormat(t, f, map[string]string{
+		"#LAX":  "Aéroport international de Los Angeles",
+		"*#LAX": "Los Angeles",
+		"#LHR":  "London Heathrow Airport",
+	})
+	f.NameLanguage = "de"
+	checkFormat(t, f, map[string]string{
+		"##KLAX": "Flughafen Los Angeles",
+	})
+
+	// A localized name wins over the display name; airports without one
+	// fall back to it.
+	f.NameLanguage = "fr"
+	f.PreferDisplayName = true
+	checkFormat(t, f, map[string]string{
+		"#LAX": "Aéroport international de Los Angeles",
+		"#LHR": "Heathrow",
+	})
+	f.NameLanguage = "es"
+	checkFormat(t, f, map[string]string{
+		"#LAX": "LAX Airport",
+	})
+
+	// JSON airport data carries localized names in a names object.
+	airports, err := ReadAirportsJSON(strings.NewReader(`[{"name": "Hamad International Airport", "iso_country": "QA", "municipality": "Doha",
+		"icao_code": "OTHH", "iata_code": "DOH", "names": {"fr": "Aéroport international Hamad"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AddAirports(airports)
+	f.NameLanguage = "fr"
+	checkFormat(t, f, map[string]string{
+		"#DOH":   "Aéroport international Hamad",
+		"##OTHH": "Aéroport international Hamad",
+	})
+}
+
+func TestVerboseIncompleteAirports(t *testing.T) {
+	f, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425"
+Remote Airstrip,AU, ,YREM,XRM,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Verbose = true
+
+	// The city form falls back to the name.
+	got, counter := process(f, "*#XRM and *##YREM and *#LAX and #mapXRM")
+	if want := "Remote Airstrip and Remote Airstrip and Los Angeles and #mapXRM"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	want := []string{
+		"#XRM resolved but has empty coordinates",
+		"#XRM resolved but has empty municipality",
+		"##YREM resolved but has empty municipality",
+	}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+
+	// The name form does not need the municipality.
+	_, counter = process(f, "#XRM")
+	if got := f.Warnings(counter); len(got) != 0 {
+		t.Errorf("Warnings = %q, want none", got)
+	}
+
+	f.Verbose = false
+	_, counter = process(f, "*#XRM")
+	if got := f.Warnings(counter); len(got) != 0 {
+		t.Errorf("Warnings without Verbose = %q, want none", got)
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	f, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates
+Remote Airstrip,AU,,YREM,XRM,"130.0, -20.0"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chain, defaultForm string
+		want               map[string]string
+	}{
+		{"city,name,code", AirportFormName, map[string]string{"*#XRM": "Remote Airstrip", "#XRM": "Remote Airstrip"}},
+		{"city,code", AirportFormName, map[string]string{"*#XRM": "XRM", "*##YREM": "YREM"}},
+		{"name,city,code", AirportFormCity, map[string]string{"#XRM": "XRM", "*#XRM": "Remote Airstrip"}},
+		{"code", AirportFormCity, map[string]string{"#XRM": "XRM"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chain, func(t *testing.T) {
+			chain, err := ParseFallbackChain(tt.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.FallbackChain = chain
+			f.DefaultForm = tt.defaultForm
+			checkFormat(t, f, tt.want)
+		})
+	}
+}
+
+func TestParseFallbackChain(t *testing.T) {
+	chain, err := ParseFallbackChain(" City , NAME,code")
+	if err != nil || !slices.Equal(chain, []string{AirportFormCity, AirportFormName, AirportFormCode}) {
+		t.Errorf("ParseFallbackChain = %q, %v", chain, err)
+	}
+	if _, err := ParseFallbackChain("city,country"); err == nil || err.Error() != `unknown form "country" (expected name, city or code)` {
+		t.Errorf("ParseFallbackChain error = %v", err)
+	}
+}
+
+func TestAddAirports(t *testing.T) {
+	f := newTestFormatter(t)
+	f.AddAirports([]*Airport{
+		{Name: "Test Field", Municipality: "Testville", ISOCountry: "XX", IATACode: "TST"},
+		{Name: "LA Hub", Municipality: "LA", IATACode: "LAX"},
+		{Name: "Kennedy", ICAOCode: "KJFK"},
+	})
+	checkFormat(t, f, map[string]string{
+		"#TST":   "Test Field",
+		"*#TST":  "Testville",
+		"#LAX":   "LA Hub",
+		"*#LAX":  "LA",
+		"##KJFK": "Kennedy",
+		"#CDG":   "Charles de Gaulle International Airport",
+		// An overridden airport is replaced under both of its codes.
+		"##KLAX": "LA Hub",
+		"#JFK":   "Kennedy",
+	})
+	f.AddAirports([]*Airport{{Name: "Both Codes", IATACode: "XYZ", ICAOCode: "KXYZ"}})
+	checkFormat(t, f, map[string]string{
+		"#XYZ":   "Both Codes",
+		"##KXYZ": "Both Codes",
+	})
+
+	// Without airport data the index is created.
+	f = &Formatter{Options: DefaultOptions()}
+	f.AddAirports([]*Airport{{Name: "Test Field", IATACode: "TST"}})
+	checkFormat(t, f, map[string]string{
+		"#TST": "Test Field",
+	})
+}
+
+func TestCityCountrySeparator(t *testing.T) {
+	f := newTestFormatter(t)
+	f.CityCountrySeparator = ", "
+	checkFormat(t, f, map[string]string{
+		"*#LAX":       "Los Angeles, US",
+		"*##LFPG":     "Paris, FR",
+		"*#[LHR,JFK]": "London, GB, New York, US",
+		"#LAX":        "Los Angeles International Airport",
+	})
+
+	f.CityCountrySeparator = " / "
+	f.DefaultForm = AirportFormCity
+	checkFormat(t, f, map[string]string{
+		"#CDG": "Paris / FR",
+	})
+
+	// Airports without a country keep the city alone.
+	f.AddAirports([]*Airport{{Name: "Test Field", Municipality: "Testville", IATACode: "TST"}})
+	checkFormat(t, f, map[string]string{
+		"#TST": "Testville",
+	})
+}
+
+func TestReverseAirportNames(t *testing.T) {
+	f := newTestFormatter(t)
+	f.ReverseNames = true
+	f.AddAirports([]*Airport{
+		{Name: "Paris Orly Airport", IATACode: "ORY"},
+		{Name: "Paris Orly", ICAOCode: "XORY"},
+		{Name: "Springfield Airport", IATACode: "SPA"},
+		{Name: "Springfield Airport", IATACode: "SPB"},
+	})
+	checkFormat(t, f, map[string]string{
+		"Fly Los Angeles International Airport to JOHN F KENNEDY  International\nAirport": "Fly #LAX to #JFK",
+		"Paris Orly Airport, then Paris Orly":                                             "#ORY, then ##XORY",
+		"Springfield Airport is ambiguous":                                                "Springfield Airport is ambiguous",
+		"Los Angeles International Airports":                                              "Los Angeles International Airports",
+		"#LAX stays a placeholder":                                                        "#LAX stays a placeholder",
+	})
+}
+
+func TestLowercaseCodes(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#lax":          "Los Angeles International Airport",
+		"#Jfk":          "John F Kennedy International Airport",
+		"*##lfpg":       "Paris",
+		"#[lax, Egll]":  "Los Angeles International Airport, London Heathrow Airport",
+		"#hashtag":      "#hashtag",
+		"#abc and #ABC": "#abc and #ABC",
+	})
+
+	// Unknown codes count as unresolved only in uppercase, unless
+	// NormalizeUnresolvedCase is set.
+	_, counter := process(f, "#abc #ABD #[lax,zzz]")
+	if got, want := counter.UnresolvedCodes(), []string{"ABD", "ZZZ"}; !slices.Equal(got, want) {
+		t.Errorf("UnresolvedCodes = %q, want %q", got, want)
+	}
+	f.NormalizeUnresolvedCase = true
+	_, counter = process(f, "#abc #ABD")
+	if got, want := counter.UnresolvedCodes(), []string{"ABC", "ABD"}; !slices.Equal(got, want) {
+		t.Errorf("with NormalizeUnresolvedCase, UnresolvedCodes = %q, want %q", got, want)
+	}
+}
+
+func TestSuggestCodes(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := map[string][]string{
+		// Closest first, then in code order.
+		"LAS":  {"LAX", "LHR"},
+		"JFX":  {"JFK", "LAX"},
+		"KLAZ": {"KLAX"},
+		"ZZZ":  nil,
+		"LA":   nil,
+	}
+	for code, want := range tests {
+		if got := f.SuggestCodes(code); !slices.Equal(got, want) {
+			t.Errorf("SuggestCodes(%q) = %q, want %q", code, got, want)
+		}
+	}
+
+	f.Verbose = true
+	_, counter := process(f, "#LAS and ##KLAZ and #ZZZ")
+	want := []string{
+		"unresolved codes: 1× ##KLAZ, 1× #LAS, 1× #ZZZ",
+		"unknown code ##KLAZ; did you mean ##KLAX?",
+		"unknown code #LAS; did you mean #LAX, #LHR?",
+	}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestAdjacentCodes(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"#LAX##EGLL":    "Los Angeles International AirportLondon Heathrow Airport",
+		"##EGLL#LAX":    "London Heathrow AirportLos Angeles International Airport",
+		"##KJFK##EGLL":  "John F Kennedy International AirportLondon Heathrow Airport",
+		"*#CDG*##EGLL":  "ParisLondon",
+		"#LAX,##EGLL":   "Los Angeles International Airport,London Heathrow Airport",
+		"#EGLL":         "#EGLL",
+		"##LAX":         "##LAX",
+		"##EGLLX":       "##EGLLX",
+		"#LAXX ##KLAXX": "#LAXX ##KLAXX",
+	})
+	_, counter := process(f, "#LAX##EGLL")
+	if got := counter.Expanded(TokenAirport); got != 2 {
+		t.Errorf("Expanded = %d, want both codes", got)
+	}
+}
+
+func TestICAOLength(t *testing.T) {
+	f := newTestFormatter(t)
+	f.AddAirports([]*Airport{
+		{Name: "Three Letter Field", ICAOCode: "XYZ"},
+		{Name: "Five Letter Field", ICAOCode: "ABCDE"},
+	})
+	f.ICAOMinLength, f.ICAOMaxLength = 3, 5
+	checkFormat(t, f, map[string]string{
+		"##XYZ":        "Three Letter Field",
+		"##EGLL":       "London Heathrow Airport",
+		"##ABCDE":      "Five Letter Field",
+		"##ABCDEF":     "##ABCDEF",
+		"#LAX##ABCDE":  "Los Angeles International AirportFive Letter Field",
+		"##XYZ#LAX":    "Three Letter FieldLos Angeles International Airport",
+		"#[XYZ,ABCDE]": "Three Letter Field, Five Letter Field",
+	})
+}
@@ -0,0 +1,316 @@
+package formatter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Token types used for expansion limits and counting.
+const (
+	TokenAirport = "airport"
+	TokenDate    = "date"
+	TokenTime    = "time"
+)
+
+// Counter tracks expanded and skipped placeholders per token type during a
+// single processing pass. Concurrent passes need a Counter each.
+type Counter struct {
+	expanded map[string]int
+	skipped  map[string]int
+
+	// unresolved counts occurrences of airport codes that could not be
+	// found in the airport data.
+	unresolved map[string]int
+
+	// referenced holds every airport that a placeholder resolved to.
+	referenced map[*Airport]bool
+
+	// unresolvedAt lists every unresolved code token in document order with
+	// its line number.
+	unresolvedAt []unresolvedToken
+
+	// line is the zero-based line of the placeholder being expanded, and
+	// lineBase the number of lines before the text being tokenized; the
+	// tokenizer keeps line up to date.
+	line, lineBase int
+
+	// tokens lists every placeholder found, in document order, and
+	// offsetBase is the number of bytes before the text being tokenized.
+	tokens     []DetectedToken
+	offsetBase int
+
+	// replaced counts placeholders per token type whose expansion differs
+	// from the placeholder as written, and replacedLines holds the one-based
+	// lines they are on.
+	replaced      map[string]int
+	replacedLines map[int]bool
+
+	// handlerFailures describes placeholders the external handler could not
+	// expand.
+	handlerFailures []string
+
+	// malformedDates describes date and time placeholders left unexpanded
+	// because they could not be parsed or have an unknown name.
+	malformedDates []string
+
+	// negativeDurations describes DUR placeholders whose end is before their
+	// start.
+	negativeDurations []string
+
+	// incomplete holds "code field" pairs for airports that resolved but
+	// lacked a field an expansion needed.
+	incomplete map[string]bool
+
+	// missingAirportData is set when airport expansion was skipped because
+	// no airport data had been loaded.
+	missingAirportData bool
+}
+
+// NewCounter returns an empty counter.
+func NewCounter() *Counter {
+	return &Counter{
+		expanded:   make(map[string]int),
+		skipped:    make(map[string]int),
+		unresolved: make(map[string]int),
+		referenced: make(map[*Airport]bool),
+		incomplete: make(map[string]bool),
+
+		replaced:      make(map[string]int),
+		replacedLines: make(map[int]bool),
+	}
+}
+
+// allow reports whether another placeholder of the given type may be
+// expanded under limit, recording it as expanded or skipped accordingly. A
+// limit of zero means unlimited. Expanders call it only once the placeholder
+// has resolved or parsed, so that unknown codes and malformed timestamps
+// neither count as expanded nor use up the limit.
+func (c *Counter) allow(tokenType string, limit int) bool {
+	if limit > 0 && c.expanded[tokenType] >= limit {
+		c.skipped[tokenType]++
+		return false
+	}
+	c.expanded[tokenType]++
+	return true
+}
+
+// Expanded returns how many placeholders of the given type were expanded.
+func (c *Counter) Expanded(tokenType string) int {
+	return c.expanded[tokenType]
+}
+
+// Count returns how many placeholders of the given type resolved or parsed,
+// whether they were expanded or skipped by a limit. Unknown codes and
+// malformed timestamps are not counted.
+func (c *Counter) Count(tokenType string) int {
+	return c.expanded[tokenType] + c.skipped[tokenType]
+}
+
+// unresolvedToken is an occurrence of an airport code that could not be
+// resolved.
+type unresolvedToken struct {
+	token string // e.g. "#ZZZ"
+	line  int    // one-based line in the input
+}
+
+// UnresolvedReport lists the distinct unresolved codes, sorted, with the
+// lines they occur on, on one line for IATA codes and one for ICAO codes,
+// e.g. "IATA: #QQQ (line 7), #ZZZ (lines 3, 9)".
+func (c *Counter) UnresolvedReport() string {
+	lines := make(map[string][]string)
+	for _, occurrence := range c.unresolvedAt {
+		line := strconv.Itoa(occurrence.line)
+		if !slices.Contains(lines[occurrence.token], line) {
+			lines[occurrence.token] = append(lines[occurrence.token], line)
+		}
+	}
+	var iata, icao []string
+	for _, code := range c.UnresolvedCodes() {
+		token := codeToken(code)
+		entry := fmt.Sprintf("%s (line %s)", token, lines[token][0])
+		if len(lines[token]) > 1 {
+			entry = fmt.Sprintf("%s (lines %s)", token, strings.Join(lines[token], ", "))
+		}
+		if len(code) != 3 {
+			icao = append(icao, entry)
+		} else {
+			iata = append(iata, entry)
+		}
+	}
+	var report []string
+	if len(iata) > 0 {
+		report = append(report, "IATA: "+strings.Join(iata, ", "))
+	}
+	if len(icao) > 0 {
+		report = append(report, "ICAO: "+strings.Join(icao, ", "))
+	}
+	return strings.Join(report, "\n")
+}
+
+// recordUnresolved notes an airport code that could not be resolved.
+func (c *Counter) recordUnresolved(code string) {
+	c.unresolved[code]++
+	c.unresolvedAt = append(c.unresolvedAt, unresolvedToken{codeToken(code), c.line + 1})
+}
+
+// recordToken notes a placeholder of a token type found at offset in the
+// text being tokenized and what it expanded to.
+func (c *Counter) recordToken(kind, tokenType, raw, expansion string, offset int) {
+	c.tokens = append(c.tokens, DetectedToken{
+		Kind:   kind,
+		Raw:    raw,
+		Value:  Render(expansion, PlainRenderer{}),
+		Offset: c.offsetBase + offset,
+		Line:   c.line + 1,
+	})
+	if expansion != raw {
+		c.replaced[tokenType]++
+		c.replacedLines[c.line+1] = true
+	}
+}
+
+// Replaced returns how many placeholders of the given type were replaced by
+// text different from the placeholder, so unresolved codes and malformed
+// timestamps are not included.
+func (c *Counter) Replaced(tokenType string) int {
+	return c.replaced[tokenType]
+}
+
+// ReplacedLines returns how many input lines hold a replaced placeholder.
+func (c *Counter) ReplacedLines() int {
+	return len(c.replacedLines)
+}
+
+// recordIncomplete notes that the airport looked up by code resolved but its
+// field was empty or unusable, so an expansion fell back or was skipped.
+func (c *Counter) recordIncomplete(code, field string) {
+	c.incomplete[code+" "+field] = true
+}
+
+// recordReferenced notes an airport that a placeholder resolved to.
+func (c *Counter) recordReferenced(airport *Airport) {
+	c.referenced[airport] = true
+}
+
+// ReferencedAirports returns the resolved airports sorted by country, then
+// name, then codes.
+func (c *Counter) ReferencedAirports() []*Airport {
+	airports := make([]*Airport, 0, len(c.referenced))
+	for airport := range c.referenced {
+		airports = append(airports, airport)
+	}
+	sort.Slice(airports, func(i, j int) bool {
+		a, b := airports[i], airports[j]
+		if a.ISOCountry != b.ISOCountry {
+			return a.ISOCountry < b.ISOCountry
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.IATACode+a.ICAOCode < b.IATACode+b.ICAOCode
+	})
+	return airports
+}
+
+// UnresolvedCodes returns the distinct unresolved airport codes, sorted.
+func (c *Counter) UnresolvedCodes() []string {
+	codes := make([]string, 0, len(c.unresolved))
+	for code := range c.unresolved {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// unresolvedSummary lists the unresolved codes with their occurrence counts,
+// most frequent first, e.g. "12× #XYZ, 3× #QQQ". Ties are in code order.
+func (c *Counter) unresolvedSummary() string {
+	codes := c.UnresolvedCodes()
+	sort.SliceStable(codes, func(i, j int) bool {
+		return c.unresolved[codes[i]] > c.unresolved[codes[j]]
+	})
+	entries := make([]string, len(codes))
+	for i, code := range codes {
+		entries[i] = fmt.Sprintf("%d× %s", c.unresolved[code], codeToken(code))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// Tokens returns every placeholder found, in document order.
+func (c *Counter) Tokens() []DetectedToken {
+	return c.tokens
+}
+
+// Warnings returns a message for each token type whose limit was exceeded
+// while counter was filled. It also reports when airport expansion was
+// skipped for lack of airport data and, with Verbose, unresolved codes by
+// frequency with the known codes closest to each, airports whose data was
+// incomplete, malformed date and time placeholders and codes that appear
+// on more than one row of the airport data.
+func (f *Formatter) Warnings(c *Counter) []string {
+	var messages []string
+	if c.missingAirportData {
+		messages = append(messages, "no airport data loaded; airport codes left unexpanded")
+	}
+	for _, tokenType := range []string{TokenAirport, TokenDate, TokenTime} {
+		if skipped := c.skipped[tokenType]; skipped > 0 {
+			messages = append(messages, fmt.Sprintf("%s limit of %d reached; %d placeholder(s) left unexpanded",
+				tokenType, f.Limits[tokenType], skipped))
+		}
+	}
+	for _, failure := range c.handlerFailures {
+		messages = append(messages, "placeholder handler failed for "+failure)
+	}
+	messages = append(messages, c.negativeDurations...)
+	if f.Verbose && len(c.unresolved) > 0 {
+		messages = append(messages, "unresolved codes: "+c.unresolvedSummary())
+		for _, code := range c.UnresolvedCodes() {
+			suggestions := f.SuggestCodes(code)
+			if len(suggestions) == 0 {
+				continue
+			}
+			for i, suggestion := range suggestions {
+				suggestions[i] = codeToken(suggestion)
+			}
+			messages = append(messages, fmt.Sprintf("unknown code %s; did you mean %s?", codeToken(code), strings.Join(suggestions, ", ")))
+		}
+	}
+	if f.Verbose {
+		incomplete := make([]string, 0, len(c.incomplete))
+		for entry := range c.incomplete {
+			incomplete = append(incomplete, entry)
+		}
+		sort.Strings(incomplete)
+		for _, entry := range incomplete {
+			code, field, _ := strings.Cut(entry, " ")
+			messages = append(messages, fmt.Sprintf("%s resolved but has empty %s", codeToken(code), field))
+		}
+		messages = append(messages, c.malformedDates...)
+		for _, conflict := range f.conflicts {
+			messages = append(messages, fmt.Sprintf("duplicate code %s in the airport data: using %q, ignoring %q",
+				codeToken(conflict.Code), conflict.Kept.Name, conflict.Ignored.Name))
+		}
+	}
+	return messages
+}
+
+// DetectedToken is a placeholder found in the input.
+type DetectedToken struct {
+	Kind   string `json:"kind"`   // placeholder form, e.g. "iata" or "t24"
+	Raw    string `json:"raw"`    // placeholder as written
+	Value  string `json:"value"`  // plain expansion; the raw text if unresolved
+	Offset int    `json:"offset"` // byte offset in the input
+	Line   int    `json:"line"`   // one-based line in the input
+}
+
+// codeToken writes an airport code as a placeholder token, e.g. "#LAX" or
+// "##KLAX". Codes other than three letters are taken for ICAO codes.
+func codeToken(code string) string {
+	if len(code) != 3 {
+		return "##" + code
+	}
+	return "#" + code
+}
@@ -0,0 +1,138 @@
+package formatter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExpansionLimits(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Limits[TokenAirport] = 2
+	f.Limits[TokenDate] = 1
+
+	got, counter := process(f, "#LAX #JFK #CDG on D(2023-05-01T10:00Z) and D(2023-05-02T10:00Z) at T24(2023-05-01T10:00Z)")
+	want := "Los Angeles International Airport John F Kennedy International Airport #CDG on 01 May 2023 and D(2023-05-02T10:00Z) at 10:00 (+00:00)"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	wantWarnings := []string{
+		"airport limit of 2 reached; 1 placeholder(s) left unexpanded",
+		"date limit of 1 reached; 1 placeholder(s) left unexpanded",
+	}
+	if got := f.Warnings(counter); !slices.Equal(got, wantWarnings) {
+		t.Errorf("Warnings = %q, want %q", got, wantWarnings)
+	}
+}
+
+func TestExpansionLimitsResolvedOnly(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Limits[TokenAirport] = 1
+	f.Limits[TokenDate] = 1
+	// Unknown codes and malformed timestamps do not use up a limit.
+	got, counter := process(f, "#ZZZ #LAX #JFK D(2023-13-40T10:00Z) D(2023-05-01T10:00Z)")
+	if want := "#ZZZ Los Angeles International Airport #JFK D(2023-13-40T10:00Z) 01 May 2023"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if got, want := counter.Count(TokenAirport), 2; got != want {
+		t.Errorf("Count(airport) = %d, want %d", got, want)
+	}
+	want := []string{"airport limit of 1 reached; 1 placeholder(s) left unexpanded"}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+}
+
+func TestExpansionLimitsUnlimited(t *testing.T) {
+	f := newTestFormatter(t)
+	got, counter := process(f, "#LAX #JFK #CDG")
+	if want := "Los Angeles International Airport John F Kennedy International Airport Charles de Gaulle International Airport"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if got := f.Warnings(counter); len(got) != 0 {
+		t.Errorf("Warnings = %q, want none", got)
+	}
+}
+
+func TestExpansionLimitsDocumentOrder(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Limits[TokenTime] = 1
+	// The limit goes to the first placeholder in the text, whatever its form.
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T10:00Z) T12(2023-05-01T11:00Z)": "10:00 (+00:00) T12(2023-05-01T11:00Z)",
+		"T12(2023-05-01T11:00Z) T24(2023-05-01T10:00Z)": "11:00AM (+00:00) T24(2023-05-01T10:00Z)",
+	})
+}
+
+func TestUnresolvedSummary(t *testing.T) {
+	tests := map[string]string{
+		"#QQQ #XYZ and #XYZ":         "2× #XYZ, 1× #QQQ",
+		"#ZZZ ##AAAA #BBB":           "1× ##AAAA, 1× #BBB, 1× #ZZZ",
+		"#QQQ #PPP *#[PPP,QQQ] #PPP": "3× #PPP, 2× #QQQ",
+	}
+	f := newTestFormatter(t)
+	f.Verbose = true
+	for input, want := range tests {
+		_, counter := process(f, input)
+		if got := counter.unresolvedSummary(); got != want {
+			t.Errorf("%s: unresolvedSummary = %q, want %q", input, got, want)
+		}
+		if warnings := f.Warnings(counter); !slices.Contains(warnings, "unresolved codes: "+want) {
+			t.Errorf("%s: Warnings = %q, want the summary", input, warnings)
+		}
+	}
+
+	f.Verbose = false
+	_, counter := process(f, "#ZZZ")
+	if warnings := f.Warnings(counter); len(warnings) != 0 {
+		t.Errorf("Warnings without Verbose = %q, want none", warnings)
+	}
+}
+
+func TestUnresolvedReport(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := map[string]string{
+		"#ZZZ\n#LAX ##QQQQ\n#QQQ\n\n*#ZZZ and #[ZZZ,LAX]\n": "IATA: #QQQ (line 3), #ZZZ (lines 1, 5)\nICAO: ##QQQQ (line 2)",
+		"#AAA #BBB\n#CCC #DDD\n#EEE #FFF #GGG":              "IATA: #AAA (line 1), #BBB (line 1), #CCC (line 2), #DDD (line 2), #EEE (line 3), #FFF (line 3), #GGG (line 3)",
+		"#LAX":                                              "",
+	}
+	for input, want := range tests {
+		_, counter := process(f, input)
+		if got := counter.UnresolvedReport(); got != want {
+			t.Errorf("%q: UnresolvedReport = %q, want %q", input, got, want)
+		}
+	}
+
+	// Lines inside skipped code fences still count.
+	f.RespectCodeFences = true
+	_, counter := process(f, "```\n#QQQ\n```\n#ZZZ")
+	if got, want := counter.UnresolvedReport(), "IATA: #ZZZ (line 4)"; got != want {
+		t.Errorf("UnresolvedReport with code fences = %q, want %q", got, want)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	f := newTestFormatter(t)
+	_, counter := process(f, "From #LAX\nto *##LFPG, #ZZZ on D(2023-05-01T10:00Z)")
+	want := []DetectedToken{
+		{Kind: "iata", Raw: "#LAX", Value: "Los Angeles International Airport", Offset: 5, Line: 1},
+		{Kind: "icao", Raw: "*##LFPG", Value: "Paris", Offset: 13, Line: 2},
+		{Kind: "iata", Raw: "#ZZZ", Value: "#ZZZ", Offset: 22, Line: 2},
+		{Kind: "d", Raw: "D(2023-05-01T10:00Z)", Value: "01 May 2023", Offset: 30, Line: 2},
+	}
+	if got := counter.Tokens(); !slices.Equal(got, want) {
+		t.Errorf("Tokens = %+v, want %+v", got, want)
+	}
+}
+
+func TestReplaced(t *testing.T) {
+	f := newTestFormatter(t)
+	_, counter := process(f, "#LAX to #ZZZ\nno placeholders\nD(2023-05-01T10:00Z) D(2023-13-40T10:00Z)\nT24(2023-05-01T10:00Z)")
+	for tokenType, want := range map[string]int{TokenAirport: 1, TokenDate: 1, TokenTime: 1} {
+		if got := counter.Replaced(tokenType); got != want {
+			t.Errorf("Replaced(%s) = %d, want %d", tokenType, got, want)
+		}
+	}
+	if got := counter.ReplacedLines(); got != 3 {
+		t.Errorf("ReplacedLines = %d, want 3", got)
+	}
+}
@@ -0,0 +1,372 @@
+package formatter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dateTimeLayouts lists the accepted ISO-8601 layouts for date/time placeholders.
+// The layout with seconds also accepts fractional seconds after them.
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04Z",
+	"2006-01-02T15:04-07:00",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// dateFormats maps each date placeholder to its output layout. Longer token
+// names come first so they are replaced before any shorter name they contain.
+var dateFormats = []struct {
+	Token  string
+	Layout string
+	Help   string
+}{
+	{"DSHORT", "02/01", "Short date (day/month)"},
+	{"DLONG", "Monday, 02 January 2006", "Long date with weekday"},
+	{"DW", "Mon, 02 Jan 2006", "Date with short weekday"},
+	{"D", "02 Jan 2006", "Date"},
+}
+
+// formattedDates matches dates as written by the DLONG, DW and D
+// placeholders, forms with a weekday first so they are not read as their
+// trailing plain date.
+var formattedDates = regexp.MustCompile(`\b(?:((?:Monday|Tuesday|Wednesday|Thursday|Friday|Saturday|Sunday), \d{2} ` +
+	`(?:January|February|March|April|May|June|July|August|September|October|November|December) \d{4})|` +
+	`((?:Mon|Tue|Wed|Thu|Fri|Sat|Sun), \d{2} (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4})|` +
+	`(\d{2} (?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec) \d{4}))\b`)
+
+// reverseFormattedDates replaces dates formatted like DLONG, DW and D output
+// with the equivalent placeholder at midnight UTC, e.g. "01 May 2023"
+// becomes "D(2023-05-01T00:00Z)". Text that does not parse as a real date,
+// or names the wrong weekday as in "Tue, 01 May 2023", is kept.
+func reverseFormattedDates(content string) string {
+	return formattedDates.ReplaceAllStringFunc(content, func(match string) string {
+		for _, format := range dateFormats {
+			if format.Token == "DSHORT" {
+				continue
+			}
+			// time.Parse accepts any weekday name, so the date must format
+			// back to the same text.
+			if t, err := time.Parse(format.Layout, match); err == nil && t.Format(format.Layout) == match {
+				return format.Token + "(" + t.Format(dateTimeLayouts[0]) + ")"
+			}
+		}
+		return match
+	})
+}
+
+// ParseDateTime parses a placeholder timestamp using the first matching layout.
+// Parsing is anchored to UTC so a zero offset is reported as "UTC" rather than
+// picking up the local zone's name.
+func ParseDateTime(value string) (time.Time, error) {
+	var t time.Time
+	var err error
+	for _, layout := range dateTimeLayouts {
+		t, err = time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return t, checkOffset(t)
+		}
+	}
+	return t, err
+}
+
+// maxZoneOffset is the largest UTC offset in real-world use, in either
+// direction.
+const maxZoneOffset = 14 * time.Hour
+
+// checkOffset reports an error when t's UTC offset is beyond ±14:00, as with
+// a mistyped "+25:00", which would otherwise produce a wrong time.
+func checkOffset(t time.Time) error {
+	_, offset := t.Zone()
+	if d := time.Duration(offset) * time.Second; d > maxZoneOffset || d < -maxZoneOffset {
+		return fmt.Errorf("UTC offset %s is out of range", t.Format("-07:00"))
+	}
+	return nil
+}
+
+// Timezone display styles for time placeholders.
+const (
+	TZStyleOffset = "offset"
+	TZStyleAbbrev = "abbrev"
+)
+
+// formatZone returns the wrapped timezone of t, e.g. "(+02:00)".
+// In abbrev style a named zone such as "UTC" or "PST" is shown instead,
+// falling back to the numeric offset when the zone has no name.
+func (f *Formatter) formatZone(t time.Time) string {
+	zone := t.Format("-07:00")
+	if f.TZStyle == TZStyleAbbrev {
+		if name, _ := t.Zone(); isZoneAbbreviation(name) {
+			zone = name
+		}
+	}
+	return strings.Replace(f.TZWrap, "%s", zone, 1)
+}
+
+// timezoneProbe is a zone that every copy of the IANA database contains.
+const timezoneProbe = "America/New_York"
+
+// CheckTimezoneDatabase reports a descriptive error when the IANA timezone
+// database cannot be loaded, as happens in minimal containers.
+func CheckTimezoneDatabase() error {
+	return checkZoneLoads(timezoneProbe)
+}
+
+// checkZoneLoads reports the error of CheckTimezoneDatabase when the zone
+// name cannot be loaded.
+func checkZoneLoads(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("timezone database not available (%v); install tzdata, set ZONEINFO to a zoneinfo.zip, or build with -tags timetzdata to embed it", err)
+	}
+	return nil
+}
+
+// isZoneAbbreviation reports whether name is an alphabetic zone abbreviation.
+// Some zones only carry numeric names like "+03", which are not useful here.
+func isZoneAbbreviation(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// dateExpander returns an expand function that formats a date placeholder
+// with layout.
+func (f *Formatter) dateExpander(layout string) func([]string, *Counter) string {
+	return func(groups []string, counter *Counter) string {
+		t, err := ParseDateTime(strings.TrimSpace(groups[1]))
+		if err != nil {
+			return groups[0]
+		}
+		if !counter.allow(TokenDate, f.Limits[TokenDate]) {
+			return groups[0]
+		}
+		return markCode(ValueDate, t.Format(time.RFC3339), t.Format(layout))
+	}
+}
+
+// timeExpander returns an expand function that formats a time placeholder
+// with layout, or with secondsLayout when TimeSeconds is set, followed by
+// its timezone. When the placeholder names an IANA zone, the time is
+// converted to it first; an unknown zone leaves the placeholder as written.
+func (f *Formatter) timeExpander(layout, secondsLayout string) func([]string, *Counter) string {
+	if f.TimeSeconds {
+		layout = secondsLayout
+	}
+	return func(groups []string, counter *Counter) string {
+		t, err := ParseDateTime(strings.TrimSpace(groups[1]))
+		if err != nil {
+			return groups[0]
+		}
+		if groups[2] != "" {
+			location, err := time.LoadLocation(groups[2])
+			if err != nil {
+				return groups[0]
+			}
+			t = t.In(location)
+		}
+		if !counter.allow(TokenTime, f.Limits[TokenTime]) {
+			return groups[0]
+		}
+		return fmt.Sprintf("%s %s", markCode(ValueTime, t.Format(time.RFC3339), t.Format(layout)), mark(ValueZone, f.formatZone(t)))
+	}
+}
+
+// dateMarker matches anything written like a date or time placeholder: an
+// uppercase name followed by a parenthesized timestamp and optional zone.
+var dateMarker = regexp.MustCompile(`\b([A-Z][A-Z0-9]*)\(\s*(\d{4}-[^()\s|T]*T[^()\s|]*)\s*(?:\|\s*([^()\s]*)\s*)?\)`)
+
+// checkDateMarkers records a warning for each date or time placeholder left
+// in content after expansion because its timestamp or zone is malformed, and
+// for each unknown placeholder name given a timestamp, such as
+// "DX(2023-05-01T10:00Z)". Placeholders skipped by a limit are not reported.
+func (f *Formatter) checkDateMarkers(content string, counter *Counter) {
+	known := map[string]bool{"DREL": true, "DUR": true, "T12": true, "T24": true}
+	for _, format := range dateFormats {
+		known[format.Token] = true
+	}
+	for _, loc := range dateMarker.FindAllStringSubmatchIndex(content, -1) {
+		name := content[loc[2]:loc[3]]
+		if slices.Contains(f.HandlerPrefixes, name) {
+			continue
+		}
+		marker := content[loc[0]:loc[1]]
+		line := counter.lineBase + strings.Count(content[:loc[0]], "\n") + 1
+		if !known[name] {
+			counter.malformedDates = append(counter.malformedDates, fmt.Sprintf("unknown placeholder %s on line %d", marker, line))
+			continue
+		}
+		if !parsesTimestamps(name, content[loc[4]:loc[5]]) {
+			counter.malformedDates = append(counter.malformedDates, fmt.Sprintf("cannot parse timestamp in %s on line %d", marker, line))
+			continue
+		}
+		if loc[6] >= 0 && strings.HasPrefix(name, "T") {
+			if _, err := time.LoadLocation(content[loc[6]:loc[7]]); err != nil {
+				counter.malformedDates = append(counter.malformedDates, fmt.Sprintf("unknown time zone in %s on line %d", marker, line))
+			}
+		}
+	}
+}
+
+// UsesNamedZones reports whether content has a time placeholder naming an
+// IANA zone, such as T24(2023-05-01T10:00Z|Europe/Paris), which needs the
+// timezone database to expand.
+func UsesNamedZones(content string) bool {
+	for _, groups := range dateMarker.FindAllStringSubmatch(content, -1) {
+		if (groups[1] == "T12" || groups[1] == "T24") && groups[3] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parsesTimestamps reports whether the timestamp of a date or time
+// placeholder parses; DUR takes two separated by ";".
+func parsesTimestamps(name, timestamps string) bool {
+	values := []string{timestamps}
+	if name == "DUR" {
+		values = strings.Split(timestamps, ";")
+		if len(values) != 2 {
+			return false
+		}
+	}
+	for _, value := range values {
+		if _, err := ParseDateTime(value); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ReferenceNow returns the time relative dates are measured from: Now, or
+// the current time if Now is not set.
+func (f *Formatter) ReferenceNow() time.Time {
+	if f.Now.IsZero() {
+		return time.Now()
+	}
+	return f.Now
+}
+
+// relativeDateExpander expands a DREL(...) placeholder to a coarse description
+// of how far the timestamp is from the reference time, such as "in 3 days".
+func (f *Formatter) relativeDateExpander(groups []string, counter *Counter) string {
+	t, err := ParseDateTime(strings.TrimSpace(groups[1]))
+	if err != nil {
+		return groups[0]
+	}
+	if !counter.allow(TokenDate, f.Limits[TokenDate]) {
+		return groups[0]
+	}
+	return markCode(ValueDate, t.Format(time.RFC3339), relativeTime(t.Sub(f.ReferenceNow())))
+}
+
+// relativeUnits are the units relativeTime describes a duration in, largest
+// first. Months and years are approximated as 30 and 365 days.
+var relativeUnits = []struct {
+	name string
+	size time.Duration
+}{
+	{"year", 365 * 24 * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"day", 24 * time.Hour},
+	{"hour", time.Hour},
+	{"minute", time.Minute},
+}
+
+// relativeTime describes d in the largest whole unit it spans, as "in N
+// units" for future times and "N units ago" for past ones.
+func relativeTime(d time.Duration) string {
+	future := d >= 0
+	if !future {
+		d = -d
+	}
+	for _, unit := range relativeUnits {
+		n := int64(d / unit.size)
+		if n < 1 {
+			continue
+		}
+		amount := fmt.Sprintf("%d %s", n, unit.name)
+		if n != 1 {
+			amount += "s"
+		}
+		if future {
+			return "in " + amount
+		}
+		return amount + " ago"
+	}
+	return "now"
+}
+
+// durationExpander expands a DUR(start;end) placeholder to the time elapsed
+// from start to end, such as "3h 15m" or "1d 2h 5m". The timestamps may be
+// in different zones. An end before the start gives a negative duration,
+// which is reported as a warning.
+func (f *Formatter) durationExpander(groups []string, counter *Counter) string {
+	start, err := ParseDateTime(strings.TrimSpace(groups[1]))
+	if err != nil {
+		return groups[0]
+	}
+	end, err := ParseDateTime(strings.TrimSpace(groups[2]))
+	if err != nil {
+		return groups[0]
+	}
+	if !counter.allow(TokenTime, f.Limits[TokenTime]) {
+		return groups[0]
+	}
+	d := end.Sub(start)
+	if d < 0 {
+		counter.negativeDurations = append(counter.negativeDurations,
+			fmt.Sprintf("negative duration in %s on line %d: the end is before the start", groups[0], counter.line+1))
+	}
+	return mark(ValueDuration, f.formatDuration(d))
+}
+
+// durationUnits are the units formatDuration writes a duration in, largest
+// first.
+var durationUnits = []struct {
+	suffix string
+	size   time.Duration
+}{
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+}
+
+// formatDuration writes d in days, hours and minutes, and seconds when
+// TimeSeconds is set, leaving out units that are zero, e.g. "3h 15m".
+func (f *Formatter) formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	smallest := time.Minute
+	if f.TimeSeconds {
+		smallest = time.Second
+	}
+	d = d.Truncate(smallest)
+	var parts []string
+	for _, unit := range durationUnits {
+		if unit.size < smallest {
+			break
+		}
+		if n := d / unit.size; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d%s", n, unit.suffix))
+			d -= n * unit.size
+		}
+	}
+	if len(parts) == 0 {
+		if f.TimeSeconds {
+			return "0s"
+		}
+		return "0m"
+	}
+	return sign + strings.Join(parts, " ")
+}
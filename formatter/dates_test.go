@@ -0,0 +1,282 @@
+package formatter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTZStyle(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := []struct {
+		style, input, want string
+	}{
+		{TZStyleOffset, "T24(2023-05-01T10:00Z)", "10:00 (+00:00)"},
+		{TZStyleAbbrev, "T24(2023-05-01T10:00Z)", "10:00 (UTC)"},
+		{TZStyleAbbrev, "T12(2023-05-01T22:30Z)", "10:30PM (UTC)"},
+		// A numeric offset has no abbreviation to show.
+		{TZStyleAbbrev, "T24(2023-05-01T10:00+02:00)", "10:00 (+02:00)"},
+	}
+	for _, tt := range tests {
+		f.TZStyle = tt.style
+		if got := f.FormatPlain(tt.input); got != tt.want {
+			t.Errorf("%s: FormatPlain(%q) = %q, want %q", tt.style, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDateFormats(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"D(2023-05-01T10:00Z)":                          "01 May 2023",
+		"DSHORT(2023-05-01T10:00Z)":                     "01/05",
+		"DLONG(2023-05-01T10:00Z)":                      "Monday, 01 May 2023",
+		"DSHORT(2023-12-24T23:30+01:00)":                "24/12",
+		"DLONG(2023-12-24T23:30-05:00)":                 "Sunday, 24 December 2023",
+		"DW(2023-05-01T10:00Z)":                         "Mon, 01 May 2023",
+		"DW(2023-12-24T23:30-05:00)":                    "Sun, 24 Dec 2023",
+		"D(2023-05-01T10:00Z) DLONG(2023-05-02T10:00Z)": "01 May 2023 Tuesday, 02 May 2023",
+	})
+}
+
+func TestWhitespaceInsideParentheses(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"D( 2023-05-01T15:04Z )":    "01 May 2023",
+		"D(2023-05-01T15:04Z   )":   "01 May 2023",
+		"D(\t2023-05-01T15:04Z)":    "01 May 2023",
+		"T24(  2023-05-01T15:04Z )": "15:04 (+00:00)",
+		"T12( 2023-05-01T15:04Z )":  "03:04PM (+00:00)",
+		// Spaces inside the timestamp itself do not make a timestamp.
+		"D(2023-05-01 T15:04Z)": "D(2023-05-01 T15:04Z)",
+		"D(2023-05-01T15: 04Z)": "D(2023-05-01T15: 04Z)",
+	})
+}
+
+func TestCheckTimezoneDatabase(t *testing.T) {
+	// A zone no database has stands in for a missing database.
+	err := checkZoneLoads("Nowhere/Missing")
+	if err == nil || !strings.Contains(err.Error(), "timezone database not available") || !strings.Contains(err.Error(), "tzdata") {
+		t.Errorf("checkZoneLoads = %v, want an error suggesting tzdata", err)
+	}
+	if err := CheckTimezoneDatabase(); err != nil {
+		t.Skip(err)
+	}
+	if err := checkZoneLoads("Europe/Paris"); err != nil {
+		t.Errorf("checkZoneLoads(Europe/Paris) = %v", err)
+	}
+}
+
+func TestUsesNamedZones(t *testing.T) {
+	tests := map[string]bool{
+		"T24(2023-05-01T10:00Z|Europe/Paris)":   true,
+		"at T12( 2023-05-01T10:00Z | UTC ) now": true,
+		"T24(2023-05-01T10:00Z)":                false,
+		"D(2023-05-01T10:00Z|Europe/Paris)":     false,
+		"no placeholders":                       false,
+	}
+	for content, want := range tests {
+		if got := UsesNamedZones(content); got != want {
+			t.Errorf("UsesNamedZones(%q) = %v, want %v", content, got, want)
+		}
+	}
+}
+
+func TestRelativeDates(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Now = time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	checkFormat(t, f, map[string]string{
+		"DREL(2023-05-01T10:00Z)":        "now",
+		"DREL(2023-05-01T10:01Z)":        "in 1 minute",
+		"DREL(2023-05-01T13:00+02:00)":   "in 1 hour",
+		"DREL(2023-05-04T09:00Z)":        "in 2 days",
+		"DREL(2023-04-30T10:00Z)":        "1 day ago",
+		"DREL(2023-03-01T10:00Z)":        "2 months ago",
+		"DREL(2025-05-01T10:00Z)":        "in 2 years",
+		"Due DREL( 2023-05-08T10:00Z ).": "Due in 7 days.",
+		"DREL(2023-13-01T10:00Z)":        "DREL(2023-13-01T10:00Z)",
+	})
+}
+
+func TestReverseDates(t *testing.T) {
+	f := newTestFormatter(t)
+	f.ReverseDates = true
+	checkFormat(t, f, map[string]string{
+		"01 May 2023":                  "D(2023-05-01T00:00Z)",
+		"Monday, 01 May 2023":          "DLONG(2023-05-01T00:00Z)",
+		"Mon, 01 May 2023":             "DW(2023-05-01T00:00Z)",
+		"Leave 24 Dec 2023, back soon": "Leave D(2023-12-24T00:00Z), back soon",
+		// Impossible dates, wrong weekdays and other placeholders are kept.
+		"31 Feb 2023":          "31 Feb 2023",
+		"Tue, 01 May 2023":     "Tue, 01 May 2023",
+		"Tuesday, 01 May 2023": "Tuesday, 01 May 2023",
+		"#LAX on 01 May 2023":  "#LAX on D(2023-05-01T00:00Z)",
+		"D(2023-05-01T10:00Z)": "D(2023-05-01T10:00Z)",
+	})
+
+	// Reversed dates format back to the original text.
+	const text = "Monday, 01 May 2023, Tue, 02 May 2023 and 03 May 2023"
+	reversed := f.FormatPlain(text)
+	f.ReverseDates = false
+	if got := f.FormatPlain(reversed); got != text {
+		t.Errorf("FormatPlain(%q) = %q, want %q", reversed, got, text)
+	}
+}
+
+func TestTZWrap(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := map[string]string{
+		"(%s)":  "10:00 (+02:00)",
+		"[%s]":  "10:00 [+02:00]",
+		"%s":    "10:00 +02:00",
+		"in %s": "10:00 in +02:00",
+	}
+	for wrap, want := range tests {
+		f.TZWrap = wrap
+		if got := f.FormatPlain("T24(2023-05-01T10:00+02:00)"); got != want {
+			t.Errorf("TZWrap %q: FormatPlain = %q, want %q", wrap, got, want)
+		}
+	}
+}
+
+func TestParseDateTimeOffsets(t *testing.T) {
+	f := newTestFormatter(t)
+	for _, value := range []string{"2023-05-01T10:00+14:00", "2023-05-01T10:00-14:00", "2023-05-01T10:00Z", "2023-05-01T10:00+05:45"} {
+		if _, err := ParseDateTime(value); err != nil {
+			t.Errorf("ParseDateTime(%q) = %v", value, err)
+		}
+	}
+	for _, value := range []string{"2023-05-01T10:00+14:01", "2023-05-01T10:00-15:00", "2023-05-01T10:00+23:59"} {
+		if _, err := ParseDateTime(value); err == nil || !strings.Contains(err.Error(), "is out of range") {
+			t.Errorf("ParseDateTime(%q) error = %v, want the offset out of range", value, err)
+		}
+	}
+	if _, err := ParseDateTime("2023-05-01T10:00+25:00"); err == nil {
+		t.Error("ParseDateTime accepted +25:00")
+	}
+
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T10:00+14:00)": "10:00 (+14:00)",
+		"T24(2023-05-01T10:00-14:00)": "10:00 (-14:00)",
+		"T24(2023-05-01T10:00+25:00)": "T24(2023-05-01T10:00+25:00)",
+		"D(2023-05-01T10:00-15:00)":   "D(2023-05-01T10:00-15:00)",
+	})
+}
+
+func TestTimeZoneConversion(t *testing.T) {
+	if err := CheckTimezoneDatabase(); err != nil {
+		t.Skip(err)
+	}
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T14:30Z|America/New_York)":    "10:30 (-04:00)",
+		"T12(2023-05-01T14:30Z|Europe/Paris)":        "04:30PM (+02:00)",
+		"T24( 2023-01-15T14:30+01:00 | Asia/Tokyo )": "22:30 (+09:00)",
+		"T24(2023-05-01T14:30Z|Mars/Base)":           "T24(2023-05-01T14:30Z|Mars/Base)",
+		"D(2023-05-01T14:30Z|Europe/Paris)":          "D(2023-05-01T14:30Z|Europe/Paris)",
+	})
+
+	f.TZStyle = TZStyleAbbrev
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T14:30Z|Europe/Paris)": "16:30 (CEST)",
+	})
+}
+
+func TestTimestampSeconds(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T14:30:05Z)":          "14:30 (+00:00)",
+		"T12(2023-05-01T14:30:05.250+02:00)": "02:30PM (+02:00)",
+		"D(2023-05-01T14:30:05-04:00)":       "01 May 2023",
+		"T24(2023-05-01T14:30:61Z)":          "T24(2023-05-01T14:30:61Z)",
+	})
+
+	f.TimeSeconds = true
+	checkFormat(t, f, map[string]string{
+		"T24(2023-05-01T14:30:05Z)":          "14:30:05 (+00:00)",
+		"T12(2023-05-01T14:30:05.250+02:00)": "02:30:05PM (+02:00)",
+		"T24(2023-05-01T14:30Z)":             "14:30:00 (+00:00)",
+		"D(2023-05-01T14:30:05Z)":            "01 May 2023",
+	})
+}
+
+func TestMalformedDateWarnings(t *testing.T) {
+	f := newTestFormatter(t)
+	f.Verbose = true
+	const input = "D(2023-05-01T10:00Z)\n" +
+		"D(2023-13-40T99:99Z)\n" +
+		"DX(2023-05-01T10:00Z) and T24(2023-05-01T10:00Z|Mars/Base)"
+	_, counter := process(f, input)
+	want := []string{
+		"cannot parse timestamp in D(2023-13-40T99:99Z) on line 2",
+		"unknown placeholder DX(2023-05-01T10:00Z) on line 3",
+		"unknown time zone in T24(2023-05-01T10:00Z|Mars/Base) on line 3",
+	}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings =\n%q\nwant\n%q", got, want)
+	}
+
+	// Placeholders skipped by a limit are only counted by the limit warning.
+	f.Limits[TokenTime] = 1
+	_, counter = process(f, "T24(2023-05-01T10:00Z) T24(2023-05-02T10:00Z)")
+	want = []string{"time limit of 1 reached; 1 placeholder(s) left unexpanded"}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings with a limit = %q, want %q", got, want)
+	}
+
+	f.Verbose = false
+	if _, counter := process(f, input); slices.ContainsFunc(f.Warnings(counter), func(w string) bool { return strings.Contains(w, "line") }) {
+		t.Errorf("Warnings without Verbose = %q, want no date warnings", f.Warnings(counter))
+	}
+}
+
+func TestDurations(t *testing.T) {
+	f := newTestFormatter(t)
+	checkFormat(t, f, map[string]string{
+		"DUR(2023-05-01T10:00Z;2023-05-01T13:15Z)":            "3h 15m",
+		"DUR(2023-05-01T10:00-04:00; 2023-05-02T08:05+02:00)": "16h 5m",
+		"DUR( 2023-05-01T10:00Z ; 2023-05-03T12:00Z )":        "2d 2h",
+		"DUR(2023-05-01T10:00Z;2023-05-01T10:00:30Z)":         "0m",
+		"DUR(2023-05-01T10:00Z;2023-05-32T10:00Z)":            "DUR(2023-05-01T10:00Z;2023-05-32T10:00Z)",
+		"DUR(2023-05-01T10:00Z)":                              "DUR(2023-05-01T10:00Z)",
+	})
+
+	f.TimeSeconds = true
+	checkFormat(t, f, map[string]string{
+		"DUR(2023-05-01T10:00Z;2023-05-01T10:01:05Z)": "1m 5s",
+		"DUR(2023-05-01T10:00Z;2023-05-01T10:00Z)":    "0s",
+	})
+
+	f.TimeSeconds = false
+	got, counter := process(f, "Back\nDUR(2023-05-01T13:15Z;2023-05-01T10:00Z)")
+	if want := "Back\n-3h 15m"; got != want {
+		t.Errorf("negative duration = %q, want %q", got, want)
+	}
+	want := []string{"negative duration in DUR(2023-05-01T13:15Z;2023-05-01T10:00Z) on line 2: the end is before the start"}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+
+	// A malformed duration is reported like other date placeholders.
+	f.Verbose = true
+	_, counter = process(f, "DUR(2023-05-01T10:00Z;2023-05-32T10:00Z)")
+	want = []string{"cannot parse timestamp in DUR(2023-05-01T10:00Z;2023-05-32T10:00Z) on line 1"}
+	if got := f.Warnings(counter); !slices.Equal(got, want) {
+		t.Errorf("Warnings with Verbose = %q, want %q", got, want)
+	}
+}
+
+func TestDatesInPunctuation(t *testing.T) {
+	checkFormat(t, newTestFormatter(t), map[string]string{
+		"(D(2023-05-01T14:30Z))":                            "(01 May 2023)",
+		"We leave D(2023-05-01T14:30Z).":                    "We leave 01 May 2023.",
+		"Leave D(2023-05-01T14:30:00.5Z), then rest":        "Leave 01 May 2023, then rest",
+		"At T24(2023-05-01T14:30+02:00); boarding":          "At 14:30 (+02:00); boarding",
+		`"DLONG(2023-05-01T14:30Z)"!`:                       `"Monday, 01 May 2023"!`,
+		"[DW(2023-05-01T14:30Z)]?":                          "[Mon, 01 May 2023]?",
+		"Flight: DUR(2023-05-01T10:00Z;2023-05-01T12:30Z).": "Flight: 2h 30m.",
+		// A period inside the parentheses is not part of a timestamp.
+		"D(2023-05-01T14:30Z.)": "D(2023-05-01T14:30Z.)",
+	})
+}
@@ -0,0 +1,399 @@
+// Package formatter expands the placeholders of itinerary text: airport
+// codes such as #LAX become airport names or cities, and timestamps in
+// D(...), T12(...) and similar placeholders become readable dates and times.
+// Whitespace is cleaned up afterwards.
+//
+// A Formatter holds its airport data and options, so any number of them
+// can be used side by side:
+//
+//	f, err := formatter.New(lookupFile)
+//	if err != nil {
+//		return err
+//	}
+//	fmt.Print(f.FormatPlain("Departure: #LAX on D(2023-05-01T10:00Z)"))
+package formatter
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/text/width"
+)
+
+// Options controls how a Formatter expands placeholders. DefaultOptions
+// returns the options a new Formatter starts with.
+type Options struct {
+	// Limits caps how many placeholders of each token type are expanded,
+	// keyed by TokenAirport, TokenDate and TokenTime. A limit of zero means
+	// unlimited.
+	Limits map[string]int
+	// Verbose adds diagnostics about the airport data to the warnings.
+	Verbose bool
+
+	// StripANSI removes ANSI color codes left in the input by other tools.
+	StripANSI bool
+	// StripInvisible removes zero-width and control characters before
+	// processing.
+	StripInvisible bool
+	// NormalizeWidth folds full-width Latin letters, digits and punctuation
+	// to their ASCII forms before placeholders are matched, e.g. "＃ＬＡＸ" to
+	// "#LAX".
+	NormalizeWidth bool
+	// RespectCodeFences leaves placeholders inside ``` fenced code blocks
+	// unexpanded.
+	RespectCodeFences bool
+
+	// ReverseDates turns formatted dates back into date placeholders
+	// instead of expanding placeholders.
+	ReverseDates bool
+	// NormalizeCodes rewrites airport code tokens in canonical form instead
+	// of expanding placeholders.
+	NormalizeCodes bool
+	// ReverseNames turns airport names back into code tokens instead of
+	// expanding placeholders.
+	ReverseNames bool
+
+	// DefaultForm is how an airport code token expands without the "*"
+	// prefix. The prefix selects the city, or the name when cities are the
+	// default.
+	DefaultForm string
+	// FallbackChain orders the airport forms tried when the requested form
+	// is empty for an airport: the forms after the requested one are tried
+	// in turn.
+	FallbackChain []string
+	// CityCountrySeparator, when set, joins the country to the city in city
+	// expansions, e.g. "Los Angeles, US".
+	CityCountrySeparator string
+	// ListSeparator joins the expansions of an airport list token.
+	ListSeparator string
+	// MapURLTemplate builds the URL for #map placeholders from {lat} and
+	// {lon}.
+	MapURLTemplate string
+	// CoordinateFormat selects how C(#ABC) placeholders show an airport's
+	// coordinates: CoordinatesRaw, CoordinatesDecimal or CoordinatesLatLon.
+	CoordinateFormat string
+	// ShowMatchedCode appends which code notation resolved an airport, e.g.
+	// "Los Angeles International Airport (via IATA)".
+	ShowMatchedCode bool
+	// Annotate keeps the original airport code token and appends the
+	// expansion in brackets, e.g. "#LAX [Los Angeles International Airport]".
+	Annotate bool
+	// NormalizeUnresolvedCase uppercases airport code tokens that do not
+	// resolve, so "#lax" is written as "#LAX".
+	NormalizeUnresolvedCase bool
+	// PreferDisplayName makes airport expansions use the display_name column
+	// when an airport has one.
+	PreferDisplayName bool
+	// NameLanguage selects a localized airport name from the name_xx
+	// columns, e.g. "fr" for name_fr. Airports without that name use their
+	// default name.
+	NameLanguage string
+	// ConsumeBrackets makes a code wrapped in square brackets, as in
+	// "[#LAX]", expand without the brackets. Brackets holding other text are
+	// kept.
+	ConsumeBrackets bool
+	// TrailingStar lets a "*" after an airport token, as in "#LAX*", select
+	// the city just like a leading one. Writing both stars is the same as
+	// one.
+	TrailingStar bool
+	// StrictCodeBoundary leaves an airport code verbatim when a letter or
+	// digit follows it, e.g. "#LAX2", instead of expanding "#LAX" and
+	// keeping the "2".
+	StrictCodeBoundary bool
+	// ICAOMinLength and ICAOMaxLength bound the length of codes written as
+	// ##CODE. ICAO codes have four letters, but some private and military
+	// fields use three or five.
+	ICAOMinLength, ICAOMaxLength int
+
+	// TZStyle selects how the timezone of a time placeholder is displayed.
+	TZStyle string
+	// TZWrap is the pattern the zone of a time is shown in; "%s" stands for
+	// the zone.
+	TZWrap string
+	// TimeSeconds shows the seconds of time placeholders, e.g. "14:30:05".
+	// Dates never show them.
+	TimeSeconds bool
+	// Now is the time DREL(...) placeholders are measured from. The zero
+	// value means the current time.
+	Now time.Time
+
+	// TrimPolicy is the whitespace cleanup applied after expansion.
+	TrimPolicy TrimPolicy
+	// TrimExempt, when set, keeps the whitespace of lines it matches as
+	// written, e.g. `^\t` for tab-indented lines. TrimExemptFences does the
+	// same for the lines of ``` fenced code blocks. Line breaks are still
+	// normalized.
+	TrimExempt       *regexp.Regexp
+	TrimExemptFences bool
+	// PreserveFormFeed keeps form feeds as page-break markers instead of
+	// turning them into line breaks.
+	PreserveFormFeed bool
+	// ExpandEscapes turns the escape sequences \n, \t, \r, \v and \f,
+	// written as a backslash and a letter, into the control characters they
+	// stand for before vertical cleanup; "\\" is a literal backslash.
+	// Otherwise escape sequences are kept as written, as in a path like
+	// "C:\videos".
+	ExpandEscapes bool
+
+	// HandlerCommand, when set, expands a placeholder written as
+	// PREFIX(content) for one of HandlerPrefixes by running the command with
+	// content on its stdin, for at most HandlerTimeout.
+	HandlerCommand  []string
+	HandlerPrefixes []string
+	HandlerTimeout  time.Duration
+}
+
+// DefaultOptions returns the options of a new Formatter.
+func DefaultOptions() Options {
+	return Options{
+		Limits:           map[string]int{},
+		DefaultForm:      AirportFormName,
+		FallbackChain:    []string{AirportFormCity, AirportFormName, AirportFormCode},
+		ListSeparator:    ", ",
+		ICAOMinLength:    4,
+		ICAOMaxLength:    4,
+		MapURLTemplate:   "https://maps.google.com/?q={lat},{lon}",
+		CoordinateFormat: CoordinatesRaw,
+		TZStyle:          TZStyleOffset,
+		TZWrap:           "(%s)",
+		TrimPolicy:       TrimPolicies["aggressive"],
+		HandlerTimeout:   5 * time.Second,
+	}
+}
+
+// Formatter expands placeholders using its own airport data and options;
+// there is no package-level state, so Formatters loaded from different
+// lookup files do not affect each other. A Formatter whose options and
+// airports are not changed may be used from several goroutines at once.
+type Formatter struct {
+	Options
+
+	// airports stores airport info using IATA or ICAO codes as keys. It is
+	// nil when no airport data was loaded.
+	airports map[string]*Airport
+
+	// conflicts holds the duplicate codes found in the airport data.
+	conflicts []AirportConflict
+}
+
+// New returns a Formatter with the default options and the airports read
+// from lookup, CSV data in the OurAirports column layout.
+func New(lookup io.Reader) (*Formatter, error) {
+	airports, err := ReadAirportsCSV(lookup)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromAirports(airports)
+}
+
+// NewFromAirports returns a Formatter with the default options and the
+// given airports, indexed under both their IATA and ICAO codes.
+func NewFromAirports(airports []*Airport) (*Formatter, error) {
+	f := &Formatter{Options: DefaultOptions()}
+	if err := f.indexAirports(airports); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// FormatPlain expands every placeholder in input and cleans up whitespace,
+// returning plain text.
+func (f *Formatter) FormatPlain(input string) string {
+	return Render(f.Process(input, NewCounter()), PlainRenderer{})
+}
+
+// Process expands every placeholder in content and cleans up whitespace,
+// recording what it found in counter. Expanded values are marked so the
+// result can be passed to Render for each output format.
+func (f *Formatter) Process(content string, counter *Counter) string {
+	for _, step := range f.processingSteps() {
+		content = step.apply(content, counter)
+	}
+	return content
+}
+
+// processingStep is one transform applied by Process.
+type processingStep struct {
+	name  string
+	apply func(content string, counter *Counter) string
+}
+
+// processingSteps returns the transforms Process runs with the current
+// options, in order.
+func (f *Formatter) processingSteps() []processingStep {
+	return append(f.expansionSteps(), f.cleanupSteps()...)
+}
+
+// expansionSteps returns the processing steps before whitespace cleanup.
+func (f *Formatter) expansionSteps() []processingStep {
+	steps := []processingStep{{"strip-markers", func(content string, _ *Counter) string {
+		return markStripper.Replace(content)
+	}}}
+	// ANSI codes go first: stripping invisible characters would remove only
+	// their escape byte and leave the rest behind.
+	if f.StripANSI {
+		steps = append(steps, processingStep{"strip-ansi", func(content string, _ *Counter) string {
+			return ansiEscape.ReplaceAllString(content, "")
+		}})
+	}
+	if f.StripInvisible {
+		steps = append(steps, processingStep{"strip-invisible", func(content string, _ *Counter) string {
+			return stripInvisibleCharacters(content)
+		}})
+	}
+
+	if f.NormalizeWidth {
+		steps = append(steps, processingStep{"normalize-width", func(content string, _ *Counter) string {
+			return width.Fold.String(content)
+		}})
+	}
+
+	// Rewriting modes keep placeholders instead of expanding them.
+	switch {
+	case f.ReverseDates || f.NormalizeCodes || f.ReverseNames:
+		if f.ReverseDates {
+			steps = append(steps, processingStep{"reverse-dates", func(content string, _ *Counter) string {
+				return reverseFormattedDates(content)
+			}})
+		}
+		if f.NormalizeCodes {
+			steps = append(steps, processingStep{"normalize-codes", func(content string, _ *Counter) string {
+				return normalizeCodeTokens(content)
+			}})
+		}
+		if f.ReverseNames {
+			steps = append(steps, processingStep{"reverse-names", func(content string, _ *Counter) string {
+				return f.reverseAirportNames(content)
+			}})
+		}
+	default:
+		specs := f.tokenSpecs()
+		names := make([]string, len(specs))
+		for i, spec := range specs {
+			names[i] = spec.Name
+		}
+		name := "expand(" + strings.Join(names, ", ") + ")"
+		if f.RespectCodeFences {
+			name += " outside code fences"
+		}
+		tokens := newTokenizer(specs, f.StrictCodeBoundary)
+		// With Verbose, date placeholders the tokenizer could not expand are
+		// reported once expansion is done.
+		replace := tokens.replace
+		if f.Verbose {
+			name += " with date checks"
+			replace = func(content string, counter *Counter) string {
+				content = tokens.replace(content, counter)
+				f.checkDateMarkers(content, counter)
+				return content
+			}
+		}
+		steps = append(steps, processingStep{name, func(content string, counter *Counter) string {
+			if !f.RespectCodeFences {
+				return replace(content, counter)
+			}
+			var b strings.Builder
+			base, offsetBase := counter.lineBase, counter.offsetBase
+			for _, region := range splitCodeFences(content) {
+				lines, size := strings.Count(region.text, "\n"), len(region.text)
+				if !region.fenced {
+					region.text = replace(region.text, counter)
+				}
+				b.WriteString(region.text)
+				counter.lineBase += lines
+				counter.offsetBase += size
+			}
+			counter.lineBase, counter.offsetBase = base, offsetBase
+			return b.String()
+		}})
+	}
+	return steps
+}
+
+// cleanupSteps returns the whitespace cleanup steps the trim policy and
+// ExpandEscapes enable.
+func (f *Formatter) cleanupSteps() []processingStep {
+	var steps []processingStep
+	if f.TrimPolicy.horizontal {
+		steps = append(steps, processingStep{"trim-horizontal", func(content string, _ *Counter) string {
+			return f.trimHorizontalWhitespace(content)
+		}})
+	}
+	if f.ExpandEscapes {
+		steps = append(steps, processingStep{"expand-escapes", func(content string, _ *Counter) string {
+			return expandEscapes(content)
+		}})
+	}
+	if f.TrimPolicy.vertical {
+		steps = append(steps, processingStep{"trim-vertical", func(content string, _ *Counter) string {
+			return f.trimVerticalWhitespace(content)
+		}})
+	}
+	return steps
+}
+
+// Pipeline lists the names of the processing steps the current options
+// enable, in order.
+func (f *Formatter) Pipeline() string {
+	var names []string
+	for _, step := range f.processingSteps() {
+		names = append(names, step.name)
+	}
+	return strings.Join(names, " → ")
+}
+
+// ansiEscape matches an ANSI SGR (color and style) escape sequence.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// stripInvisibleCharacters removes zero-width characters and C0/C1 control
+// characters that can hide inside placeholders, e.g. "#L\u200bAX". Tabs and
+// the line-break characters handled by trimVerticalWhitespace are kept.
+func stripInvisibleCharacters(content string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\n', '\t', '\r', '\v', '\f':
+			return r
+		case '\u200B', '\u200C', '\u200D', '\u2060', '\uFEFF':
+			return -1
+		}
+		if r < 0x20 || (r >= 0x7F && r <= 0x9F) {
+			return -1
+		}
+		return r
+	}, content)
+}
+
+// fenceRegion is a run of whole lines that is either inside or outside a
+// ``` fenced code block.
+type fenceRegion struct {
+	text   string
+	fenced bool
+}
+
+// splitCodeFences splits content into consecutive regions of lines inside and
+// outside fenced code blocks. Fence delimiter lines belong to the fenced
+// region. An unterminated fence extends to the end of the content.
+func splitCodeFences(content string) []fenceRegion {
+	var regions []fenceRegion
+	var current strings.Builder
+	inFence := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		isDelimiter := strings.HasPrefix(strings.TrimSpace(line), "```")
+		if isDelimiter && !inFence {
+			regions = append(regions, fenceRegion{text: current.String()})
+			current.Reset()
+			inFence = true
+			current.WriteString(line)
+			continue
+		}
+		current.WriteString(line)
+		if isDelimiter && inFence {
+			regions = append(regions, fenceRegion{text: current.String(), fenced: true})
+			current.Reset()
+			inFence = false
+		}
+	}
+	return append(regions, fenceRegion{text: current.String(), fenced: inFence})
+}
@@ -0,0 +1,176 @@
+package formatter
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAirportsCSV is a small airport table in the OurAirports column layout.
+const testAirportsCSV = `name,iso_country,municipality,icao_code,iata_code,coordinates
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425"
+John F Kennedy International Airport,US,New York,KJFK,JFK,"-73.7789, 40.6398"
+Charles de Gaulle International Airport,FR,Paris,LFPG,CDG,"2.55, 49.012779"
+London Heathrow Airport,GB,London,EGLL,LHR,"-0.461941, 51.4706"
+`
+
+// newTestFormatter returns a Formatter with the default options and the
+// airports of testAirportsCSV.
+func newTestFormatter(t testing.TB) *Formatter {
+	t.Helper()
+	f, err := New(strings.NewReader(testAirportsCSV))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return f
+}
+
+// process processes input with f and returns the plain output and the
+// counter it filled.
+func process(f *Formatter, input string) (string, *Counter) {
+	counter := NewCounter()
+	return Render(f.Process(input, counter), PlainRenderer{}), counter
+}
+
+// checkFormat checks that f formats each input of tests as expected.
+func checkFormat(t *testing.T, f *Formatter, tests map[string]string) {
+	t.Helper()
+	for input, want := range tests {
+		if got := f.FormatPlain(input); got != want {
+			t.Errorf("FormatPlain(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestMarkStripper(t *testing.T) {
+	f := newTestFormatter(t)
+	input := "a" + string(markStart) + "dfake" + string(markEnd) + " #LAX"
+	if got, want := f.FormatPlain(input), "adfake Los Angeles International Airport"; got != want {
+		t.Errorf("FormatPlain = %q, want %q", got, want)
+	}
+}
+
+func TestRespectCodeFences(t *testing.T) {
+	f := newTestFormatter(t)
+	f.RespectCodeFences = true
+	checkFormat(t, f, map[string]string{
+		"#LAX\n```\n#LAX\n```\n#JFK":             "Los Angeles International Airport\n```\n#LAX\n```\nJohn F Kennedy International Airport",
+		"#LAX\n```go\nD(2023-05-01T10:00Z)\n```": "Los Angeles International Airport\n```go\nD(2023-05-01T10:00Z)\n```",
+		// An unterminated fence runs to the end of the document.
+		"#LAX\n```\n#JFK\n#CDG": "Los Angeles International Airport\n```\n#JFK\n#CDG",
+	})
+
+	// Without the option fences are ordinary text.
+	f.RespectCodeFences = false
+	checkFormat(t, f, map[string]string{
+		"```\n#LAX\n```": "```\nLos Angeles International Airport\n```",
+	})
+}
+
+func TestStripInvisible(t *testing.T) {
+	f := newTestFormatter(t)
+	const input = "From #L\u200bAX to ##EG\u2060LL\x07 on D(2023-05-01T10:00Z) \ufeff\tok"
+	const want = "From Los Angeles International Airport to London Heathrow Airport on 01 May 2023 ok"
+	if got := f.FormatPlain(input); got == want {
+		t.Errorf("FormatPlain without StripInvisible = %q, want the hidden characters to block matching", got)
+	}
+	f.StripInvisible = true
+	checkFormat(t, f, map[string]string{
+		input:                       want,
+		"\u200c\u200d#CDG\x00":      "Charles de Gaulle International Airport",
+		"#LAX\u0085 and\u009b #JFK": "Los Angeles International Airport and John F Kennedy International Airport",
+	})
+
+	// Tabs and line-break characters are left for whitespace cleanup.
+	if got, want := stripInvisibleCharacters("Line 1\r\nLine\t2\fLine 3\v"), "Line 1\r\nLine\t2\fLine 3\v"; got != want {
+		t.Errorf("stripInvisibleCharacters = %q, want %q", got, want)
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	f := newTestFormatter(t)
+	const split = "From #\x1b[1mLAX\x1b[0m"
+	if got := f.FormatPlain(split); got != split {
+		t.Errorf("FormatPlain without StripANSI = %q, want the input unchanged", got)
+	}
+	f.StripANSI = true
+	checkFormat(t, f, map[string]string{
+		split: "From Los Angeles International Airport",
+		"\x1b[32m#LAX\x1b[0m to \x1b[1;31m#JFK\x1b[m": "Los Angeles International Airport to John F Kennedy International Airport",
+		"D(\x1b[33m2023-05-01T10:00Z\x1b[0m)":         "01 May 2023",
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	const expand = "expand(list, map, coordinates, country, icao, iata, dshort, dlong, dw, d, drel, dur, t12, t24)"
+	tests := []struct {
+		name  string
+		setup func(f *Formatter)
+		want  string
+	}{
+		{"defaults", func(f *Formatter) {},
+			"strip-markers → " + expand + " → trim-horizontal → trim-vertical"},
+		{"input cleanup", func(f *Formatter) { f.StripANSI, f.StripInvisible, f.NormalizeWidth = true, true, true },
+			"strip-markers → strip-ansi → strip-invisible → normalize-width → " + expand + " → trim-horizontal → trim-vertical"},
+		{"code fences", func(f *Formatter) { f.RespectCodeFences = true },
+			"strip-markers → " + expand + " outside code fences → trim-horizontal → trim-vertical"},
+		{"no trimming", func(f *Formatter) { f.TrimPolicy = TrimPolicies["none"] },
+			"strip-markers → " + expand},
+		{"rewriting modes", func(f *Formatter) { f.ReverseDates, f.NormalizeCodes, f.ReverseNames = true, true, true },
+			"strip-markers → reverse-dates → normalize-codes → reverse-names → trim-horizontal → trim-vertical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFormatter(t)
+			tt.setup(f)
+			if got := f.Pipeline(); got != tt.want {
+				t.Errorf("Pipeline =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeWidth(t *testing.T) {
+	f := newTestFormatter(t)
+	const fullWidth = "＃ＬＡＸ on Ｄ（２０２３－０５－０１Ｔ１０：００Ｚ）"
+	if got := f.FormatPlain(fullWidth); got != fullWidth {
+		t.Errorf("FormatPlain without NormalizeWidth = %q, want the input unchanged", got)
+	}
+	f.NormalizeWidth = true
+	checkFormat(t, f, map[string]string{
+		fullWidth:   "Los Angeles International Airport on 01 May 2023",
+		"＊＃＃ＥＧＬＬ":   "London",
+		"ｶﾀｶﾅ ＃ＪＦＫ": "カタカナ John F Kennedy International Airport",
+	})
+}
+
+func TestIndependentFormatters(t *testing.T) {
+	f := newTestFormatter(t)
+	other, err := New(strings.NewReader(`name,iso_country,municipality,icao_code,iata_code,coordinates
+LA Hub,US,Los Angeles,KLAX,LAX,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both Formatters are used from several goroutines at once.
+	var wg sync.WaitGroup
+	for range 4 {
+		for _, tt := range []struct {
+			f    *Formatter
+			want string
+		}{
+			{f, "Los Angeles International Airport, John F Kennedy International Airport"},
+			{other, "LA Hub, #JFK"},
+		} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got := tt.f.FormatPlain("#LAX, #JFK"); got != tt.want {
+					t.Errorf("FormatPlain = %q, want %q", got, tt.want)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
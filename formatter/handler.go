@@ -0,0 +1,112 @@
+package formatter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// handlerPrefixPattern is the form a handler prefix must take.
+var handlerPrefixPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// ParseHandlerPrefixes parses a comma-separated list of handler prefixes
+// for Options.HandlerPrefixes. Prefixes that name a built-in placeholder
+// are rejected.
+func ParseHandlerPrefixes(list string) ([]string, error) {
+	builtin := make(map[string]bool)
+	for _, format := range dateFormats {
+		builtin[format.Token] = true
+	}
+	for _, name := range []string{"DREL", "DUR", "T12", "T24", "C", "CTRY"} {
+		builtin[name] = true
+	}
+
+	var prefixes []string
+	for _, prefix := range strings.Split(list, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if !handlerPrefixPattern.MatchString(prefix) {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+		if builtin[prefix] {
+			return nil, fmt.Errorf("prefix %q is a built-in placeholder", prefix)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// handlerSpecs returns a token spec for each configured handler prefix.
+func (f *Formatter) handlerSpecs() []tokenSpec {
+	if len(f.HandlerCommand) == 0 {
+		return nil
+	}
+	var specs []tokenSpec
+	for _, prefix := range f.HandlerPrefixes {
+		specs = append(specs, tokenSpec{
+			Placeholder: Placeholder{
+				Name:    "handler-" + strings.ToLower(prefix),
+				Pattern: regexp.QuoteMeta(prefix) + `\(([^()\n]*)\)`,
+				Syntax:  prefix + "(content)",
+				Help:    "Output of the -placeholder-handler command given content on stdin",
+			},
+			Start:     prefix[:1],
+			WordStart: true,
+			expand:    f.handlerExpander(prefix),
+		})
+	}
+	return specs
+}
+
+// handlerExpander returns an expand function that runs the handler command
+// for a placeholder with the given prefix. A failed or timed-out command
+// leaves the placeholder as written and is reported as a warning.
+func (f *Formatter) handlerExpander(prefix string) func([]string, *Counter) string {
+	return func(groups []string, counter *Counter) string {
+		output, err := f.runHandler(prefix, groups[1])
+		if err != nil {
+			counter.handlerFailures = append(counter.handlerFailures, fmt.Sprintf("%s: %v", groups[0], err))
+			return groups[0]
+		}
+		return output
+	}
+}
+
+// handlerWaitDelay is how long runHandler waits for the handler's output to
+// close once the command has exited or been killed. A process the handler
+// started in the background can hold the output open long after that.
+const handlerWaitDelay = 100 * time.Millisecond
+
+// runHandler runs the handler command with content on stdin and returns its
+// stdout without the trailing newline. The prefix is passed in the
+// PLACEHOLDER_PREFIX environment variable.
+func (f *Formatter) runHandler(prefix, content string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.HandlerTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, f.HandlerCommand[0], f.HandlerCommand[1:]...)
+	cmd.Env = append(os.Environ(), "PLACEHOLDER_PREFIX="+prefix)
+	cmd.WaitDelay = handlerWaitDelay
+	cmd.Stdin = strings.NewReader(content)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("handler timed out after %v", f.HandlerTimeout)
+		}
+		if errors.Is(err, exec.ErrWaitDelay) {
+			return "", errors.New("handler exited but left its output open")
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%v: %s", err, message)
+		}
+		return "", err
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
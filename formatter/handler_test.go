@@ -0,0 +1,104 @@
+package formatter
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+	"time"
+)
+
+// lookPath returns the path of a command the test needs, skipping the test
+// when it is not installed.
+func lookPath(t *testing.T, command string) string {
+	t.Helper()
+	path, err := exec.LookPath(command)
+	if err != nil {
+		t.Skip(err)
+	}
+	return path
+}
+
+func TestHandler(t *testing.T) {
+	f := newTestFormatter(t)
+	f.HandlerCommand = []string{lookPath(t, "tr"), "a-z", "A-Z"}
+	f.HandlerPrefixes = []string{"X", "WX"}
+	checkFormat(t, f, map[string]string{
+		"X(hello) at #LAX":     "HELLO at Los Angeles International Airport",
+		"WX(sunny, 21c)":       "SUNNY, 21C",
+		"BOX(hello)":           "BOX(hello)",
+		"X() and X(a) X(b)":    "and A B",
+		"D(2023-05-01T10:00Z)": "01 May 2023",
+	})
+
+	// Without a command the prefixes are plain text.
+	f.HandlerCommand = nil
+	checkFormat(t, f, map[string]string{
+		"X(hello)": "X(hello)",
+	})
+}
+
+func TestHandlerFailure(t *testing.T) {
+	f := newTestFormatter(t)
+	f.HandlerPrefixes = []string{"X"}
+	f.HandlerCommand = []string{lookPath(t, "false")}
+	got, counter := process(f, "X(hello)")
+	if got != "X(hello)" {
+		t.Errorf("output = %q, want the placeholder left as written", got)
+	}
+	if warnings := f.Warnings(counter); !slices.Contains(warnings, "placeholder handler failed for X(hello): exit status 1") {
+		t.Errorf("Warnings = %q, want the failure reported", warnings)
+	}
+
+	f.HandlerCommand = []string{lookPath(t, "sleep"), "5"}
+	f.HandlerTimeout = 50 * time.Millisecond
+	_, counter = process(f, "X(hello)")
+	if warnings := f.Warnings(counter); !slices.Contains(warnings, "placeholder handler failed for X(hello): handler timed out after 50ms") {
+		t.Errorf("Warnings = %q, want the timeout reported", warnings)
+	}
+}
+
+func TestHandlerBackgroundProcess(t *testing.T) {
+	f := newTestFormatter(t)
+	f.HandlerPrefixes = []string{"X"}
+	f.HandlerTimeout = 200 * time.Millisecond
+	sh := lookPath(t, "sh")
+
+	// A process left running in the background keeps the handler's output
+	// open, whether the handler exits or is killed at the timeout.
+	tests := []struct {
+		script, warning string
+	}{
+		{"sleep 10 & echo x", "placeholder handler failed for X(hello): handler exited but left its output open"},
+		{"sleep 10 & wait", "placeholder handler failed for X(hello): handler timed out after 200ms"},
+	}
+	for _, tt := range tests {
+		f.HandlerCommand = []string{sh, "-c", tt.script}
+		start := time.Now()
+		got, counter := process(f, "X(hello)")
+		if elapsed := time.Since(start); elapsed > f.HandlerTimeout+time.Second {
+			t.Errorf("%s: returned after %v, want within the timeout", tt.script, elapsed)
+		}
+		if got != "X(hello)" {
+			t.Errorf("%s: output = %q, want the placeholder left as written", tt.script, got)
+		}
+		if warnings := f.Warnings(counter); !slices.Contains(warnings, tt.warning) {
+			t.Errorf("%s: Warnings = %q, want %q", tt.script, warnings, tt.warning)
+		}
+	}
+}
+
+func TestParseHandlerPrefixes(t *testing.T) {
+	prefixes, err := ParseHandlerPrefixes(" X, WX_2")
+	if err != nil || !slices.Equal(prefixes, []string{"X", "WX_2"}) {
+		t.Errorf("ParseHandlerPrefixes = %q, %v", prefixes, err)
+	}
+	for list, want := range map[string]string{
+		"X,DLONG": `prefix "DLONG" is a built-in placeholder`,
+		"2X":      `invalid prefix "2X"`,
+		"X,":      `invalid prefix ""`,
+	} {
+		if _, err := ParseHandlerPrefixes(list); err == nil || err.Error() != want {
+			t.Errorf("ParseHandlerPrefixes(%q) error = %v, want %s", list, err, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package formatter
+
+import "strings"
+
+// ProcessIncremental processes content like Process, but copies the output
+// of lines found in cache, which maps input lines to the plain output they
+// produced before, instead of processing them again. Runs of other lines
+// are processed together. It returns the processed content and how many
+// lines were copied. Copied lines carry no marks, so they are not
+// highlighted, counted or exported.
+func (f *Formatter) ProcessIncremental(content string, cache map[string]string, counter *Counter) (string, int) {
+	var b strings.Builder
+	var changed []string
+	reused := 0
+	flush := func(next int) {
+		if len(changed) > 0 {
+			// Line numbers in warnings count from the start of the content.
+			counter.lineBase = next - len(changed)
+			b.WriteString(f.Process(strings.Join(changed, "\n"), counter))
+			counter.lineBase = 0
+			b.WriteString("\n")
+			changed = changed[:0]
+		}
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		output, exists := cache[line]
+		if !exists || strings.TrimSpace(line) == "" {
+			changed = append(changed, line)
+			continue
+		}
+		flush(i)
+		b.WriteString(markStripper.Replace(output))
+		b.WriteString("\n")
+		reused++
+	}
+	flush(len(lines))
+	// Blank lines on either side of copied lines still need collapsing, and
+	// the split above added a final line break the content did not have.
+	processed := strings.TrimSuffix(b.String(), "\n")
+	if f.TrimPolicy.vertical {
+		processed = f.trimVerticalWhitespace(processed)
+	}
+	return processed, reused
+}
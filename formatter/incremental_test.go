@@ -0,0 +1,30 @@
+package formatter
+
+import "testing"
+
+func TestProcessIncremental(t *testing.T) {
+	f := newTestFormatter(t)
+	const content = "From #LAX\n\n\n\nto #JFK on D(2023-05-01T10:00Z)\nvia #ZZZ\nthen #CDG"
+	// The copied lines are taken from the cache as they are.
+	cache := map[string]string{
+		"From #LAX": "cached LAX line",
+		"then #CDG": "cached CDG line",
+		"not used":  "never copied",
+	}
+	counter := NewCounter()
+	got, reused := f.ProcessIncremental(content, cache, counter)
+	want := "cached LAX line\n\nto John F Kennedy International Airport on 01 May 2023\nvia #ZZZ\ncached CDG line"
+	if got := Render(got, PlainRenderer{}); got != want || reused != 2 {
+		t.Errorf("ProcessIncremental = %q, %d, want %q, 2", got, reused, want)
+	}
+	if got, want := counter.UnresolvedReport(), "IATA: #ZZZ (line 6)"; got != want {
+		t.Errorf("UnresolvedReport = %q, want %q", got, want)
+	}
+
+	// With the lines' real output the result matches Process.
+	full, _ := process(f, content)
+	cache = map[string]string{"From #LAX": "From Los Angeles International Airport", "then #CDG": "then Charles de Gaulle International Airport"}
+	if got, _ := f.ProcessIncremental(content, cache, NewCounter()); Render(got, PlainRenderer{}) != full {
+		t.Errorf("ProcessIncremental = %q, want %q", Render(got, PlainRenderer{}), full)
+	}
+}
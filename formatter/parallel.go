@@ -0,0 +1,104 @@
+package formatter
+
+import (
+	"strings"
+	"sync"
+)
+
+// ProcessParallel processes content like Process, but runs the expansion
+// steps on up to workers chunks of lines concurrently. The chunks are
+// reassembled in order before whitespace cleanup, which can join lines
+// across chunk boundaries. The result matches Process unless expansion
+// limits or RespectCodeFences make lines depend on their position.
+func (f *Formatter) ProcessParallel(content string, workers int, counter *Counter) string {
+	chunks := splitChunks(content, workers)
+	outputs := make([]string, len(chunks))
+	counters := make([]*Counter, len(chunks))
+	steps := f.expansionSteps()
+
+	var wg sync.WaitGroup
+	line, offset := 0, 0
+	for i, chunk := range chunks {
+		counters[i] = NewCounter()
+		counters[i].lineBase, counters[i].offsetBase = line, offset
+		line += strings.Count(chunk, "\n")
+		offset += len(chunk)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, step := range steps {
+				chunk = step.apply(chunk, counters[i])
+			}
+			outputs[i] = chunk
+		}()
+	}
+	wg.Wait()
+
+	for _, chunkCounter := range counters {
+		counter.merge(chunkCounter)
+	}
+	processed := strings.Join(outputs, "")
+	for _, step := range f.cleanupSteps() {
+		processed = step.apply(processed, counter)
+	}
+	return processed
+}
+
+// splitChunks splits content at line breaks into at most n chunks of about
+// the same number of lines, keeping the line breaks. A chunk only ends after
+// a line that closes every bracket it opens, so that placeholders spanning
+// lines, such as an airport list, are not split.
+func splitChunks(content string, n int) []string {
+	lines := strings.SplitAfter(content, "\n")
+	size := (len(lines) + n - 1) / n
+	var chunks []string
+	start := 0
+	for end := size; start < len(lines); end += size {
+		end = min(end, len(lines))
+		for end < len(lines) && !closesBrackets(lines[end-1]) {
+			end++
+		}
+		chunks = append(chunks, strings.Join(lines[start:end], ""))
+		start = end
+	}
+	return chunks
+}
+
+// closesBrackets reports whether every "[" and "(" in line is followed by a
+// closing bracket.
+func closesBrackets(line string) bool {
+	return strings.LastIndex(line, "[") <= strings.LastIndex(line, "]") &&
+		strings.LastIndex(line, "(") <= strings.LastIndex(line, ")")
+}
+
+// merge adds the counts and findings of other, which counted the text after
+// c's, to c.
+func (c *Counter) merge(other *Counter) {
+	for tokenType, n := range other.expanded {
+		c.expanded[tokenType] += n
+	}
+	for tokenType, n := range other.skipped {
+		c.skipped[tokenType] += n
+	}
+	for code, n := range other.unresolved {
+		c.unresolved[code] += n
+	}
+	for airport := range other.referenced {
+		c.referenced[airport] = true
+	}
+	for entry := range other.incomplete {
+		c.incomplete[entry] = true
+	}
+	for tokenType, n := range other.replaced {
+		c.replaced[tokenType] += n
+	}
+	for line := range other.replacedLines {
+		c.replacedLines[line] = true
+	}
+	c.unresolvedAt = append(c.unresolvedAt, other.unresolvedAt...)
+	c.tokens = append(c.tokens, other.tokens...)
+	c.handlerFailures = append(c.handlerFailures, other.handlerFailures...)
+	c.malformedDates = append(c.malformedDates, other.malformedDates...)
+	c.negativeDurations = append(c.negativeDurations, other.negativeDurations...)
+	c.missingAirportData = c.missingAirportData || other.missingAirportData
+}
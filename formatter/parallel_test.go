@@ -0,0 +1,61 @@
+package formatter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessParallel(t *testing.T) {
+	f := newLookupFormatter(t)
+	f.Verbose = true
+	content := syntheticItinerary(300) + "Route: #[LHR,\n  CDG,\n  JFK]\n\n\n\nEnd D(2023-13-01T10:00Z)"
+
+	serialCounter := NewCounter()
+	serial := f.Process(content, serialCounter)
+	for _, workers := range []int{1, 2, 3, 8, 64} {
+		counter := NewCounter()
+		if got := f.ProcessParallel(content, workers, counter); got != serial {
+			t.Errorf("%d workers: output differs from Process", workers)
+		}
+		if got, want := counter.UnresolvedReport(), serialCounter.UnresolvedReport(); got != want {
+			t.Errorf("%d workers: UnresolvedReport =\n%s\nwant\n%s", workers, got, want)
+		}
+		if got, want := f.Warnings(counter), f.Warnings(serialCounter); !slices.Equal(got, want) {
+			t.Errorf("%d workers: Warnings = %q, want %q", workers, got, want)
+		}
+		if !slices.Equal(counter.ReferencedAirports(), serialCounter.ReferencedAirports()) {
+			t.Errorf("%d workers: ReferencedAirports differ from Process", workers)
+		}
+		if !slices.Equal(counter.Tokens(), serialCounter.Tokens()) {
+			t.Errorf("%d workers: Tokens differ from Process", workers)
+		}
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	content := "a\nb\n#[LAX,\nJFK]\nc\nd"
+	for n := 1; n <= 7; n++ {
+		chunks := splitChunks(content, n)
+		if len(chunks) > n || strings.Join(chunks, "") != content {
+			t.Errorf("splitChunks(%d) = %q, want at most %d chunks joining to the content", n, chunks, n)
+		}
+		for _, chunk := range chunks {
+			if strings.HasSuffix(chunk, "#[LAX,\n") {
+				t.Errorf("splitChunks(%d) = %q, want the list kept in one chunk", n, chunks)
+			}
+		}
+	}
+}
+
+// BenchmarkProcessParallel processes the itinerary of BenchmarkProcess on
+// four workers.
+func BenchmarkProcessParallel(b *testing.B) {
+	f := newLookupFormatter(b)
+	content := syntheticItinerary(20000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for range b.N {
+		f.ProcessParallel(content, 4, NewCounter())
+	}
+}
@@ -0,0 +1,94 @@
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syntheticCodes are airports of ../airport-lookup.csv that the synthetic
+// itinerary cycles through, as IATA and ICAO codes.
+var syntheticCodes = [][2]string{
+	{"VXC", "FQLC"}, {"LIW", "VYLK"}, {"LHR", "EGLL"}, {"BRX", "MDBH"}, {"MVR", "FKKL"}, {"OGZ", "URMO"},
+	{"CUR", "TNCC"}, {"OHO", "UHOO"}, {"KRL", "ZWKL"}, {"KWJ", "RKJJ"}, {"VHY", "LFLV"}, {"HAD", "ESMT"},
+	{"KYZ", "UNKY"}, {"LOS", "DNMM"}, {"BSO", "RPUO"}, {"ATA", "SPHZ"}, {"WYA", "YWHA"}, {"GUR", "AYGN"},
+	{"STX", "TISX"}, {"ZCL", "MMZC"}, {"TAR", "LIBG"}, {"TXE", "WITK"}, {"QRA", "FAGM"}, {"AQJ", "OJAQ"},
+}
+
+// syntheticZones are the UTC offsets the synthetic itinerary's timestamps
+// cycle through.
+var syntheticZones = []string{"Z", "+02:00", "-05:00", "+05:30", "-11:00", "+13:00"}
+
+// syntheticItinerary returns an itinerary of n segments, each with airport
+// codes in several forms, dates and times in several zones, unknown codes
+// and untidy whitespace.
+func syntheticItinerary(n int) string {
+	var b strings.Builder
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	timestamp := func(t time.Time, zone string) string {
+		return t.Format("2006-01-02T15:04") + zone
+	}
+	for i := range n {
+		from, to := syntheticCodes[i%len(syntheticCodes)], syntheticCodes[(i*7+3)%len(syntheticCodes)]
+		departs := start.Add(time.Duration(i) * (26*time.Hour + 17*time.Minute))
+		arrives := departs.Add(time.Duration(i%9+1) * 47 * time.Minute)
+		zone := syntheticZones[i%len(syntheticZones)]
+		fmt.Fprintf(&b, "Segment %d:   from #%s   to  *##%s\n", i+1, from[0], to[1])
+		fmt.Fprintf(&b, "  Departs D(%s) at T12(%s),\tarrives T24(%s)  \n", timestamp(departs, zone), timestamp(departs, zone), timestamp(arrives, "Z"))
+		if i%5 == 0 {
+			b.WriteString("\n\n\n   Note: gate for #XYZ is unknown;\tsee ##QQQQ.\n")
+		}
+		fmt.Fprintf(&b, "Via *#%s and ##%s (flight #%d)\n\n", to[0], from[1], 100+i)
+	}
+	return b.String()
+}
+
+// newLookupFormatter returns a Formatter with the airports of the bundled
+// ../airport-lookup.csv.
+func newLookupFormatter(t testing.TB) *Formatter {
+	t.Helper()
+	file, err := os.Open("../airport-lookup.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	f, err := New(file)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return f
+}
+
+// TestProcessGolden checks that the output for a synthetic itinerary is
+// unchanged from testdata/synthetic.golden, which was written by the
+// original implementation that scanned the input once per placeholder form.
+func TestProcessGolden(t *testing.T) {
+	golden, err := os.ReadFile("testdata/synthetic.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := newLookupFormatter(t).FormatPlain(syntheticItinerary(200))
+	if got == string(golden) {
+		return
+	}
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(golden), "\n")
+	for i := range min(len(gotLines), len(wantLines)) {
+		if gotLines[i] != wantLines[i] {
+			t.Fatalf("line %d = %q, want %q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+	t.Fatalf("output has %d lines, want %d", len(gotLines), len(wantLines))
+}
+
+// BenchmarkProcess processes a synthetic itinerary of several megabytes.
+func BenchmarkProcess(b *testing.B) {
+	f := newLookupFormatter(b)
+	content := syntheticItinerary(20000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for range b.N {
+		f.Process(content, NewCounter())
+	}
+}
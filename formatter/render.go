@@ -0,0 +1,98 @@
+package formatter
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ValueKind identifies what an expanded placeholder value represents, so each
+// output format can style it appropriately.
+type ValueKind rune
+
+const (
+	ValueAirport     ValueKind = 'a'
+	ValueCity        ValueKind = 'c'
+	ValueDate        ValueKind = 'd'
+	ValueTime        ValueKind = 't'
+	ValueZone        ValueKind = 'z'
+	ValueMapLink     ValueKind = 'm'
+	ValueCoordinates ValueKind = 'g'
+	ValueDuration    ValueKind = 'u'
+	ValueCountry     ValueKind = 'n'
+)
+
+// Expanded values are wrapped in these private-use runes while processing so
+// that renderers can tell them apart from literal input text after whitespace
+// cleanup has run over the whole document. markCodeEnd follows the airport
+// code or timestamp, if any, that a value was produced from.
+const (
+	markStart   = '\uE000'
+	markEnd     = '\uE001'
+	markCodeEnd = '\uE002'
+)
+
+// markStripper removes stray marker runes from input so they cannot be
+// mistaken for expanded values.
+var markStripper = strings.NewReplacer(string(markStart), "", string(markEnd), "", string(markCodeEnd), "")
+
+// Value is an expanded value recovered from processed content.
+type Value struct {
+	Kind ValueKind
+	Text string
+	// Code is the airport code an airport or city value was looked up by,
+	// or the RFC 3339 timestamp of a date or time value.
+	Code string
+}
+
+// mark wraps an expanded value of the given kind for later rendering.
+func mark(kind ValueKind, value string) string {
+	return string(markStart) + string(rune(kind)) + strings.TrimSpace(value) + string(markEnd)
+}
+
+// markCode wraps an expanded value together with the airport code it was
+// looked up by or the timestamp it was formatted from.
+func markCode(kind ValueKind, code, value string) string {
+	return string(markStart) + string(rune(kind)) + code + string(markCodeEnd) + strings.TrimSpace(value) + string(markEnd)
+}
+
+// Renderer formats processed content for one output format.
+type Renderer interface {
+	// Text renders literal input text, escaping it if the format requires.
+	Text(text string) string
+	// Value renders an expanded placeholder value.
+	Value(value Value) string
+}
+
+// Render converts marked, processed content into the final output of r.
+func Render(content string, r Renderer) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexRune(content, markStart)
+		if start < 0 {
+			break
+		}
+		end := strings.IndexRune(content[start:], markEnd)
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(r.Text(content[:start]))
+		marked := content[start+utf8.RuneLen(markStart) : end]
+		kind, size := utf8.DecodeRuneInString(marked)
+		value := Value{Kind: ValueKind(kind), Text: marked[size:]}
+		if code, text, found := strings.Cut(value.Text, string(markCodeEnd)); found {
+			value.Code, value.Text = code, text
+		}
+		b.WriteString(r.Value(value))
+		content = content[end+utf8.RuneLen(markEnd):]
+	}
+	b.WriteString(r.Text(content))
+	return b.String()
+}
+
+// PlainRenderer renders text without any formatting.
+type PlainRenderer struct{}
+
+func (PlainRenderer) Text(text string) string { return text }
+
+func (PlainRenderer) Value(value Value) string { return value.Text }
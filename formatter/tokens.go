@@ -0,0 +1,257 @@
+package formatter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tokenSpec describes one placeholder form recognized by the tokenizer.
+type tokenSpec struct {
+	Placeholder
+	Start string // bytes a placeholder of this form can begin with
+
+	// tokenType is the TokenAirport, TokenDate or TokenTime type the form's
+	// replacements are counted under, or "" for handler forms.
+	tokenType string
+
+	// Code marks forms ending in an airport code, which StrictCodeBoundary
+	// refuses to match when a letter or digit follows.
+	Code bool
+	// WordStart marks forms that only match when no letter or digit
+	// precedes them, so that they do not match inside a longer word.
+	WordStart bool
+
+	// expand returns the replacement for a match. groups[0] is the whole
+	// match and the rest are the pattern's submatches.
+	expand func(groups []string, counter *Counter) string
+}
+
+// Placeholder describes one placeholder form for documentation and editor
+// support.
+type Placeholder struct {
+	Name    string `json:"name"`    // short identifier, e.g. "iata"
+	Pattern string `json:"pattern"` // regular expression matching the whole placeholder
+	Syntax  string `json:"syntax"`  // placeholder form, e.g. "#ABC"
+	Help    string `json:"help"`    // what the placeholder expands to
+	Example string `json:"example"` // sample input for ExampleAirports and ExampleTime
+}
+
+// Placeholders returns the placeholder forms the current options recognize,
+// in precedence order. The patterns use Go's RE2 syntax.
+func (f *Formatter) Placeholders() []Placeholder {
+	var placeholders []Placeholder
+	for _, spec := range f.tokenSpecs() {
+		placeholders = append(placeholders, spec.Placeholder)
+	}
+	return placeholders
+}
+
+// tokenSpecs returns the placeholder forms in precedence order: when two
+// forms match at the same position the earlier one wins, so ICAO codes come
+// before IATA codes and longer date tokens before "D". Placeholders are
+// matched left to right, so in "#ABC##WXYZ" the IATA code ends at the
+// second "#" and the ICAO code after it is matched on its own.
+func (f *Formatter) tokenSpecs() []tokenSpec {
+	// Codes match in any letter case and are looked up in uppercase. A code
+	// not written in uppercase must end at a word boundary, so that words
+	// such as "#hashtag" are not read as codes. An uppercase code takes the
+	// whole run of uppercase letters, and a run too long for its notation is
+	// left verbatim by the expander, so "#EGLL" is never read as "#EGL"
+	// followed by "L".
+	listCode := fmt.Sprintf(`[A-Za-z]{%d,%d}`, min(3, f.ICAOMinLength), max(3, f.ICAOMaxLength))
+	icaoCode := fmt.Sprintf(`([A-Z]{%d,}|[A-Za-z]{%d,%d}\b)`, f.ICAOMinLength, f.ICAOMinLength, f.ICAOMaxLength)
+	iataCode := `([A-Z]{3,}|[A-Za-z]{3}\b)`
+	// A trailing "*" is captured only when it may stand for the city prefix;
+	// otherwise the group is empty and the star stays literal text.
+	trailingStar := `()`
+	if f.TrailingStar {
+		trailingStar = `(\*?)`
+	}
+
+	specs := []tokenSpec{
+		// Airport lists: supports *#[LAX,SFO,EGLL]
+		{Placeholder: Placeholder{Name: "list", Pattern: `(\*?)#\[\s*(` + listCode + `(?:\s*,\s*` + listCode + `)*)\s*\]` + trailingStar,
+			Syntax: "#[ABC,ABCD]", Help: "List of airports; prefix * for cities", Example: "#[LAX,LFPG]"}, Start: "*#", tokenType: TokenAirport, expand: f.expandAirportList},
+		// Map links: supports #mapLAX and #mapKLAX
+		{Placeholder: Placeholder{Name: "map", Pattern: `#map([A-Z]{4}|[A-Z]{3})`,
+			Syntax: "#mapABC", Help: "Map link to the airport's coordinates", Example: "#mapLAX"}, Start: "#", Code: true, tokenType: TokenAirport, expand: f.expandMapLink},
+		// Coordinates: supports C(#ABC) and C(##ABCD)
+		{Placeholder: Placeholder{Name: "coordinates", Pattern: `C\((?:##([A-Z]{4})|#([A-Z]{3}))\)`,
+			Syntax: "C(#ABC)", Help: "The airport's coordinates, per -coordinate-format", Example: "C(#LAX)"}, Start: "C", WordStart: true, tokenType: TokenAirport, expand: f.expandCoordinates},
+		// Countries: supports CTRY(#ABC) and CTRY(##ABCD)
+		{Placeholder: Placeholder{Name: "country", Pattern: `CTRY\((?:##([A-Z]{4})|#([A-Z]{3}))\)`,
+			Syntax: "CTRY(#ABC)", Help: "Name of the airport's country", Example: "CTRY(#CDG)"}, Start: "C", WordStart: true, tokenType: TokenAirport, expand: f.expandCountry},
+		// ICAO codes: supports *##ABCD
+		{Placeholder: Placeholder{Name: "icao", Pattern: `(\*?)##` + icaoCode + trailingStar,
+			Syntax: "##ABCD", Help: "Airport name from an ICAO code; prefix * for the city", Example: "##KLAX *##LFPG"}, Start: "*#", Code: true, tokenType: TokenAirport, expand: f.expandICAO},
+		// IATA codes: supports *#ABC. A "##" prefix marks an ICAO-style
+		// token that did not match above; it is left verbatim.
+		{Placeholder: Placeholder{Name: "iata", Pattern: `(\*?)(#?)#` + iataCode + trailingStar,
+			Syntax: "#ABC", Help: "Airport name from an IATA code; prefix * for the city", Example: "#LAX *#CDG"}, Start: "*#", Code: true, tokenType: TokenAirport, expand: f.expandIATA},
+	}
+	// Bracketed codes: [#ABC] and [##ABCD] expand without their brackets.
+	// They are tried first so the plain code forms do not match inside them.
+	if f.ConsumeBrackets {
+		var bracketed []tokenSpec
+		for _, spec := range specs {
+			if spec.Name != "icao" && spec.Name != "iata" {
+				continue
+			}
+			bracketed = append(bracketed, tokenSpec{
+				Placeholder: Placeholder{
+					Name:    "bracketed-" + spec.Name,
+					Pattern: `\[(?:` + spec.Pattern + `)\]`,
+					Syntax:  "[" + spec.Syntax + "]",
+					Help:    spec.Help + ", without the brackets",
+					Example: "[" + strings.Fields(spec.Example)[0] + "]",
+				},
+				Start:     "[",
+				tokenType: spec.tokenType,
+				expand:    spec.expand,
+			})
+		}
+		specs = append(bracketed, specs...)
+	}
+	// Timestamps are captured in the shape of the dateTimeLayouts only, so
+	// that nothing next to them, such as the "." in "D(...Z.)", is read as
+	// part of one.
+	timestamp := `(\d{4}-\d{2}-\d{2}T\d{1,2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:\d{2}))`
+	// Dates: D(...), DSHORT(...), DLONG(...)
+	for _, format := range dateFormats {
+		specs = append(specs, tokenSpec{
+			Placeholder: Placeholder{
+				Name:    strings.ToLower(format.Token),
+				Pattern: format.Token + `\(\s*` + timestamp + `\s*\)`,
+				Syntax:  format.Token + "(timestamp)",
+				Help:    format.Help,
+				Example: format.Token + "(" + ExampleTime + ")",
+			},
+			Start:     format.Token[:1],
+			tokenType: TokenDate,
+			expand:    f.dateExpander(format.Layout),
+		})
+	}
+	// Relative dates: DREL(...)
+	specs = append(specs, tokenSpec{Placeholder: Placeholder{Name: "drel", Pattern: `DREL\(\s*` + timestamp + `\s*\)`,
+		Syntax: "DREL(timestamp)", Help: "Date relative to now, or to -now", Example: "DREL(2025-03-18T14:30-04:00)"}, Start: "D", tokenType: TokenDate, expand: f.relativeDateExpander})
+	// Durations: DUR(start;end)
+	specs = append(specs, tokenSpec{Placeholder: Placeholder{Name: "dur", Pattern: `DUR\(\s*` + timestamp + `\s*;\s*` + timestamp + `\s*\)`,
+		Syntax: "DUR(start;end)", Help: "Time elapsed from start to end, e.g. 3h 15m", Example: "DUR(" + ExampleTime + ";2025-03-16T09:05+01:00)"}, Start: "D", tokenType: TokenTime, expand: f.durationExpander})
+	// Times: T12(...), T24(...), optionally converted to an IANA zone given
+	// after a "|", as in T24(...|Europe/Paris).
+	zone := `(?:\|\s*([A-Za-z0-9_/+-]+)\s*)?`
+	specs = append(specs,
+		tokenSpec{Placeholder: Placeholder{Name: "t12", Pattern: `T12\(\s*` + timestamp + `\s*` + zone + `\)`,
+			Syntax: "T12(timestamp[|zone])", Help: "12-hour time with its zone, converted to zone if given", Example: "T12(" + ExampleTime + ")"}, Start: "T", tokenType: TokenTime, expand: f.timeExpander("03:04PM", "03:04:05PM")},
+		tokenSpec{Placeholder: Placeholder{Name: "t24", Pattern: `T24\(\s*` + timestamp + `\s*` + zone + `\)`,
+			Syntax: "T24(timestamp[|zone])", Help: "24-hour time with its zone, converted to zone if given", Example: "T24(" + ExampleTime + "|Europe/Paris)"}, Start: "T", tokenType: TokenTime, expand: f.timeExpander("15:04", "15:04:05")},
+	)
+	// External handlers: PREFIX(...) for each HandlerPrefixes entry.
+	specs = append(specs, f.handlerSpecs()...)
+	return specs
+}
+
+// tokenizer recognizes every placeholder form in a single pass over the
+// input. It jumps between bytes that can start a placeholder and tries each
+// form's anchored pattern there, so text without placeholders is skipped
+// quickly and each placeholder is matched exactly once.
+type tokenizer struct {
+	specs   []tokenSpec
+	regexes []*regexp.Regexp
+	starts  string // union of the specs' start bytes
+
+	// strictCodeBoundary refuses code forms followed by a letter or digit.
+	strictCodeBoundary bool
+}
+
+// newTokenizer compiles specs into a tokenizer.
+func newTokenizer(specs []tokenSpec, strictCodeBoundary bool) *tokenizer {
+	t := &tokenizer{specs: specs, strictCodeBoundary: strictCodeBoundary}
+	for _, spec := range specs {
+		t.regexes = append(t.regexes, regexp.MustCompile(`^(?:`+spec.Pattern+`)`))
+		for _, c := range spec.Start {
+			if !strings.ContainsRune(t.starts, c) {
+				t.starts += string(c)
+			}
+		}
+	}
+	return t
+}
+
+// replace expands every placeholder in content. At any position the
+// leftmost placeholder wins, and among forms matching at the same position
+// the first spec wins. It keeps counter.line at the line of each placeholder.
+func (t *tokenizer) replace(content string, counter *Counter) string {
+	var b strings.Builder
+	b.Grow(len(content))
+	last, pos := 0, 0
+	lines, counted := 0, 0 // line breaks in content[:counted]
+	for {
+		next := strings.IndexAny(content[pos:], t.starts)
+		if next < 0 {
+			break
+		}
+		pos += next
+		lines += strings.Count(content[counted:pos], "\n")
+		counted = pos
+		counter.line = counter.lineBase + lines
+		if expansion, end, ok := t.expandAt(content, pos, counter); ok {
+			b.WriteString(content[last:pos])
+			b.WriteString(expansion)
+			last, pos = end, end
+			continue
+		}
+		pos++
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// expandAt tries every spec at pos and expands the first match, returning
+// the expansion and the end offset of the placeholder.
+func (t *tokenizer) expandAt(content string, pos int, counter *Counter) (string, int, bool) {
+	for i, spec := range t.specs {
+		if strings.IndexByte(spec.Start, content[pos]) < 0 {
+			continue
+		}
+		loc := t.regexes[i].FindStringSubmatchIndex(content[pos:])
+		if loc == nil {
+			continue
+		}
+		if spec.WordStart && pos > 0 && isCodeContinuation(content[pos-1]) {
+			continue
+		}
+		// Go regexps have no lookahead, so the boundary is checked here.
+		if spec.Code && t.strictCodeBoundary && pos+loc[1] < len(content) && isCodeContinuation(content[pos+loc[1]]) {
+			continue
+		}
+		groups := make([]string, len(loc)/2)
+		for j := range groups {
+			if loc[2*j] >= 0 {
+				groups[j] = content[pos+loc[2*j] : pos+loc[2*j+1]]
+			}
+		}
+		expansion := spec.expand(groups, counter)
+		counter.recordToken(spec.Name, spec.tokenType, groups[0], expansion, pos)
+		return expansion, pos + loc[1], true
+	}
+	return "", pos, false
+}
+
+// isCodeContinuation reports whether c would continue an airport code.
+func isCodeContinuation(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// ExampleTime is the timestamp used in placeholder examples.
+const ExampleTime = "2025-03-15T14:30-04:00"
+
+// ExampleAirports is the small airport table placeholder examples expand
+// against, so that they do not depend on a lookup file.
+var ExampleAirports = []*Airport{
+	{Name: "Los Angeles International Airport", ISOCountry: "US", Municipality: "Los Angeles",
+		ICAOCode: "KLAX", IATACode: "LAX", Coordinates: "-118.408, 33.9425"},
+	{Name: "Charles de Gaulle International Airport", ISOCountry: "FR", Municipality: "Paris",
+		ICAOCode: "LFPG", IATACode: "CDG", Coordinates: "2.55, 49.012779"},
+}
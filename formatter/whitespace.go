@@ -0,0 +1,131 @@
+package formatter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TrimPolicy is a preset combination of whitespace cleanup behaviors, one of
+// TrimPolicies.
+type TrimPolicy struct {
+	horizontal    bool // trim whitespace within lines at all
+	collapseInner bool // collapse inner whitespace runs to a single space
+	keepIndent    bool // keep leading whitespace on each line
+	keepTabs      bool // treat tabs as text rather than whitespace
+	vertical      bool // normalize line breaks and collapse blank lines
+}
+
+// TrimPolicies lists the whitespace cleanup presets by name.
+var TrimPolicies = map[string]TrimPolicy{
+	"aggressive":  {horizontal: true, collapseInner: true, vertical: true},
+	"trailing":    {horizontal: true, keepIndent: true, vertical: true},
+	"indent-safe": {horizontal: true, collapseInner: true, keepIndent: true, vertical: true},
+	"tabs-safe":   {horizontal: true, collapseInner: true, keepTabs: true, vertical: true},
+	"none":        {},
+}
+
+// trimHorizontalWhitespace removes excessive horizontal whitespace. Lines
+// matching TrimExempt and, with TrimExemptFences, the lines of fenced code
+// blocks are kept as written, so that aligned tables survive.
+func (f *Formatter) trimHorizontalWhitespace(content string) string {
+	if !f.TrimPolicy.horizontal {
+		return content
+	}
+	if f.TrimExempt == nil && !f.TrimExemptFences {
+		return f.trimLines(content)
+	}
+
+	regions := []fenceRegion{{text: content}}
+	if f.TrimExemptFences {
+		regions = splitCodeFences(content)
+	}
+	var b strings.Builder
+	b.Grow(len(content))
+	for _, region := range regions {
+		switch {
+		case region.fenced:
+			b.WriteString(region.text)
+		case f.TrimExempt == nil:
+			b.WriteString(f.trimLines(region.text))
+		default:
+			for _, line := range strings.SplitAfter(region.text, "\n") {
+				if f.TrimExempt.MatchString(strings.TrimSuffix(line, "\n")) {
+					b.WriteString(line)
+				} else {
+					b.WriteString(f.trimLines(line))
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// trimLines trims the whitespace of every line in content. Under the default
+// policy, leading and trailing whitespace is dropped from each line and inner
+// runs collapse to a single space; other policies keep indentation, inner
+// runs or tabs. Trailing whitespace is always dropped. It scans the content
+// once, so a single very long line costs no more than the output.
+func (f *Formatter) trimLines(content string) string {
+	policy := f.TrimPolicy
+	var b strings.Builder
+	b.Grow(len(content))
+	lineStart := true
+	pending := -1 // offset where the current whitespace run began, or -1
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		switch {
+		case r == '\n':
+			b.WriteByte('\n')
+			lineStart, pending = true, -1
+		case unicode.IsSpace(r) && !(r == '\t' && policy.keepTabs) && !(r == '\f' && f.PreserveFormFeed):
+			if pending < 0 {
+				pending = i
+			}
+		default:
+			if pending >= 0 {
+				switch {
+				case lineStart && !policy.keepIndent:
+					// Drop leading whitespace.
+				case lineStart || !policy.collapseInner:
+					b.WriteString(content[pending:i])
+				default:
+					b.WriteByte(' ')
+				}
+				pending = -1
+			}
+			b.WriteString(content[i : i+size])
+			lineStart = false
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// trimVerticalWhitespace turns runs of carriage returns, vertical tabs and,
+// unless PreserveFormFeed is set, form feeds into line breaks, and collapses
+// blank lines. Escape sequences such as a literal `\v` are left alone; see
+// expandEscapes.
+func (f *Formatter) trimVerticalWhitespace(content string) string {
+	if !f.TrimPolicy.vertical {
+		return content
+	}
+	if f.PreserveFormFeed {
+		content = regexp.MustCompile("[\\r\\v]+").ReplaceAllString(content, "\n")
+	} else {
+		content = regexp.MustCompile("[\\r\\v\\f]+").ReplaceAllString(content, "\n")
+	}
+	content = regexp.MustCompile("\n{3,}").ReplaceAllString(content, "\n\n")
+	return content
+}
+
+// escapeReplacer turns backslash escape sequences into the characters they
+// stand for; a doubled backslash stands for a single one.
+var escapeReplacer = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t", `\r`, "\r", `\v`, "\v", `\f`, "\f")
+
+// expandEscapes replaces the escape sequences \n, \t, \r, \v and \f in
+// content with the control characters they stand for, for ExpandEscapes.
+func expandEscapes(content string) string {
+	return escapeReplacer.Replace(content)
+}
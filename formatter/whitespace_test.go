@@ -0,0 +1,129 @@
+package formatter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTrimPolicies(t *testing.T) {
+	f := newTestFormatter(t)
+	const input = "  Indented\ttext   at  #LAX  \n\n\n\n\tNext\t\tline  \r\nend"
+	tests := map[string]string{
+		"aggressive":  "Indented text at Los Angeles International Airport\n\nNext line\nend",
+		"trailing":    "  Indented\ttext   at  Los Angeles International Airport\n\n\tNext\t\tline\nend",
+		"indent-safe": "  Indented text at Los Angeles International Airport\n\n\tNext line\nend",
+		"tabs-safe":   "Indented\ttext at Los Angeles International Airport\n\n\tNext\t\tline\nend",
+		"none":        "  Indented\ttext   at  Los Angeles International Airport  \n\n\n\n\tNext\t\tline  \r\nend",
+	}
+	if len(tests) != len(TrimPolicies) {
+		t.Errorf("%d trim policies tested, want all %d", len(tests), len(TrimPolicies))
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			f.TrimPolicy = TrimPolicies[name]
+			if got := f.FormatPlain(input); got != want {
+				t.Errorf("FormatPlain = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTrimHorizontalWhitespace(t *testing.T) {
+	f := newTestFormatter(t)
+	tests := map[string]string{
+		"  a  b\t\tc  ":        "a b c",
+		"a\n  b \n\tc":         "a\nb\nc",
+		"a   b\u3000c":         "a b c",
+		"Café  au\tlait\n\n x": "Café au lait\n\nx",
+		"":                     "",
+	}
+	for input, want := range tests {
+		if got := f.trimHorizontalWhitespace(input); got != want {
+			t.Errorf("trimHorizontalWhitespace(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestLiteralEscapes(t *testing.T) {
+	f := newTestFormatter(t)
+	// Without ExpandEscapes a backslash followed by a letter is text, as in
+	// a Windows path.
+	checkFormat(t, f, map[string]string{
+		`Saved to C:\videos\vacation\new.mp4`: `Saved to C:\videos\vacation\new.mp4`,
+		`#LAX\r\n#JFK\t\f`:                    `Los Angeles International Airport\r\nJohn F Kennedy International Airport\t\f`,
+	})
+
+	f.ExpandEscapes = true
+	checkFormat(t, f, map[string]string{
+		`#LAX\n#JFK`:           "Los Angeles International Airport\nJohn F Kennedy International Airport",
+		`a\tb\\tc`:             "a\tb\\tc",
+		`one\v\vtwo\fthree`:    "one\ntwo\nthree",
+		`C:\\videos\\vacation`: `C:\videos\vacation`,
+	})
+}
+
+// longLine returns a single line of n words, each followed by a run of
+// spaces and tabs.
+func longLine(n int) string {
+	return strings.Repeat("word \t  ", n)
+}
+
+func TestTrimHorizontalWhitespaceLongLine(t *testing.T) {
+	f := newTestFormatter(t)
+	got := f.trimHorizontalWhitespace(longLine(100000))
+	if want := strings.TrimSuffix(strings.Repeat("word ", 100000), " "); got != want {
+		t.Errorf("trimHorizontalWhitespace returned %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// BenchmarkTrimHorizontalWhitespace trims a single line of several megabytes.
+func BenchmarkTrimHorizontalWhitespace(b *testing.B) {
+	f := newTestFormatter(b)
+	content := longLine(500000)
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for range b.N {
+		f.trimHorizontalWhitespace(content)
+	}
+}
+
+func TestPreserveFormFeed(t *testing.T) {
+	f := newTestFormatter(t)
+	const input = "Page 1: #LAX\f\fPage 2:  #JFK \f\nPage 3\r\n"
+	// Within lines, form feeds are whitespace like any other.
+	if got, want := f.FormatPlain(input), "Page 1: Los Angeles International Airport Page 2: John F Kennedy International Airport\nPage 3\n"; got != want {
+		t.Errorf("FormatPlain = %q, want %q", got, want)
+	}
+	f.PreserveFormFeed = true
+	if got, want := f.FormatPlain(input), "Page 1: Los Angeles International Airport\f\fPage 2: John F Kennedy International Airport \f\nPage 3\n"; got != want {
+		t.Errorf("FormatPlain with PreserveFormFeed = %q, want %q", got, want)
+	}
+	f.ExpandEscapes = true
+	if got, want := f.FormatPlain(`A\fB\rC`), "A\fB\nC"; got != want {
+		t.Errorf("FormatPlain of escapes = %q, want %q", got, want)
+	}
+}
+
+func TestTrimExempt(t *testing.T) {
+	f := newTestFormatter(t)
+	const input = "  Flight   times:  \n\tLAX   10:00\n\tJFK   18:30  \n```\n  a    b\n```\n  end  "
+	if got, want := f.trimHorizontalWhitespace(input), "Flight times:\nLAX 10:00\nJFK 18:30\n```\na b\n```\nend"; got != want {
+		t.Errorf("without exemptions = %q, want %q", got, want)
+	}
+
+	f.TrimExempt = regexp.MustCompile(`^\t`)
+	if got, want := f.trimHorizontalWhitespace(input), "Flight times:\n\tLAX   10:00\n\tJFK   18:30  \n```\na b\n```\nend"; got != want {
+		t.Errorf("TrimExempt = %q, want %q", got, want)
+	}
+
+	f.TrimExempt, f.TrimExemptFences = nil, true
+	if got, want := f.trimHorizontalWhitespace(input), "Flight times:\nLAX 10:00\nJFK 18:30\n```\n  a    b\n```\nend"; got != want {
+		t.Errorf("TrimExemptFences = %q, want %q", got, want)
+	}
+
+	f.TrimExempt = regexp.MustCompile(`^\t`)
+	if got, want := f.trimHorizontalWhitespace(input), "Flight times:\n\tLAX   10:00\n\tJFK   18:30  \n```\n  a    b\n```\nend"; got != want {
+		t.Errorf("both = %q, want %q", got, want)
+	}
+}
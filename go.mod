@@ -1,5 +1,10 @@
-module main
+module github.com/Greatuyi/Text-Formatter
 
 go 1.23.2
 
+require (
+	golang.org/x/term v0.34.0
+	golang.org/x/text v0.28.0
+)
 
+require golang.org/x/sys v0.35.0 // indirect
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// verticalBreaks are the characters that whitespace cleanup turns into line
+// breaks, and verticalEscapes the escape sequences -escapes turns into line
+// breaks or those characters. Input containing them does not keep its line
+// structure, so its output cannot be matched line by line.
+var (
+	verticalBreaks  = []string{"\r", "\v", "\f"}
+	verticalEscapes = []string{`\n`, `\r`, `\v`, `\f`}
+)
+
+// baseOutputCache maps each non-blank line of the base input to the line it
+// became in the previous output, for -base. Lines that produced different
+// output in different places are left out. It returns an error explaining
+// why the previous output cannot be reused.
+func (c *config) baseOutputCache(f *formatter.Formatter, base, previousOutput string) (map[string]string, error) {
+	switch {
+	case f.RespectCodeFences:
+		return nil, errors.New("-respect-code-fences makes lines depend on their surroundings")
+	case f.TrimExemptFences:
+		return nil, errors.New("-trim-exempt-fences makes lines depend on their surroundings")
+	case f.Limits[formatter.TokenAirport] > 0 || f.Limits[formatter.TokenDate] > 0 || f.Limits[formatter.TokenTime] > 0:
+		return nil, errors.New("expansion limits make lines depend on their position")
+	case c.outputEncoding != "":
+		return nil, errors.New("the previous output is not UTF-8")
+	}
+	breaks := verticalBreaks
+	if f.ExpandEscapes {
+		breaks = append(breaks, verticalEscapes...)
+	}
+	for _, escape := range breaks {
+		if strings.Contains(base, escape) {
+			return nil, errors.New("the base input contains line breaks other than \\n")
+		}
+	}
+
+	inputLines := nonBlankLines(base)
+	outputLines := nonBlankLines(previousOutput)
+	if len(inputLines) != len(outputLines) {
+		return nil, fmt.Errorf("the base input has %d non-blank lines but the previous output has %d",
+			len(inputLines), len(outputLines))
+	}
+
+	cache := make(map[string]string)
+	ambiguous := make(map[string]bool)
+	for i, line := range inputLines {
+		if output, exists := cache[line]; exists && output != outputLines[i] {
+			ambiguous[line] = true
+		}
+		cache[line] = outputLines[i]
+	}
+	for line := range ambiguous {
+		delete(cache, line)
+	}
+	return cache, nil
+}
+
+// nonBlankLines returns the lines of text that hold more than whitespace.
+func nonBlankLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// readBase reads the base input for -base and the previous output at
+// outputPath. It reports whether a previous output exists.
+func readBase(basePath, outputPath string) (base, previousOutput string, exists bool, err error) {
+	data, err := os.ReadFile(basePath)
+	if err != nil {
+		return "", "", false, err
+	}
+	base, err = expandIncludes(string(data), basePath, nil)
+	if err != nil {
+		return "", "", false, err
+	}
+	output, err := os.ReadFile(outputPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return base, "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	return base, string(output), true, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// Section headers inserted by -legs.
+const (
+	outboundHeader = "Outbound"
+	returnHeader   = "Return"
+)
+
+// airportVisit is an airport value found on a line of processed content.
+type airportVisit struct {
+	airport *formatter.Airport
+	line    int
+}
+
+// airportCollector is a renderer that renders plain text while recording
+// the codes of the airport and city values it sees.
+type airportCollector struct {
+	codes []string
+}
+
+func (c *airportCollector) Text(text string) string { return text }
+
+func (c *airportCollector) Value(value formatter.Value) string {
+	if (value.Kind == formatter.ValueAirport || value.Kind == formatter.ValueCity) && value.Code != "" {
+		c.codes = append(c.codes, value.Code)
+	}
+	return value.Text
+}
+
+// airportVisits returns the airports of f on lines of processed content, in
+// document order.
+func airportVisits(f *formatter.Formatter, lines []string) []airportVisit {
+	var visits []airportVisit
+	for i, line := range lines {
+		collector := &airportCollector{}
+		formatter.Render(line, collector)
+		for _, code := range collector.codes {
+			if airport, exists := f.Lookup(code); exists {
+				visits = append(visits, airportVisit{airport, i})
+			}
+		}
+	}
+	return visits
+}
+
+// returnLegStart finds the line where the return leg of a round trip begins,
+// or -1 when the visits do not look like a round trip. The trip must end at
+// the airport it started from; the turnaround is the middle airport of the
+// route, and the return leg begins where the route leaves it. When the
+// turnaround is written twice in a row, as with an arrival followed by the
+// next departure, the return leg begins at the second mention.
+func returnLegStart(visits []airportVisit) int {
+	if len(visits) < 3 || visits[0].airport != visits[len(visits)-1].airport {
+		return -1
+	}
+
+	// Collapse repeated mentions of the same airport into runs of visits.
+	type run struct{ first, last int }
+	var runs []run
+	for i, visit := range visits {
+		if len(runs) > 0 && visits[runs[len(runs)-1].last].airport == visit.airport {
+			runs[len(runs)-1].last = i
+			continue
+		}
+		runs = append(runs, run{i, i})
+	}
+	if len(runs) < 3 {
+		return -1
+	}
+
+	turn := runs[len(runs)/2]
+	if visits[turn.first].airport == visits[0].airport {
+		return -1
+	}
+	if turn.last > turn.first && visits[turn.last].line > visits[turn.first].line {
+		return visits[turn.last].line
+	}
+	// A single mention of the turnaround: the return leg starts on the line
+	// of the next airport, unless both are on one line and cannot be split.
+	next := visits[turn.last+1]
+	if next.line == visits[turn.last].line {
+		return -1
+	}
+	return next.line
+}
+
+// sectionStart moves a header position back from line to the start of its
+// paragraph, without passing the line after floor.
+func sectionStart(lines []string, line, floor int) int {
+	for line > floor+1 && strings.TrimSpace(lines[line-1]) != "" {
+		line--
+	}
+	return line
+}
+
+// insertLegHeaders adds "Outbound" and "Return" headers to content processed
+// by f when its airports describe a round trip. It reports whether they were
+// added.
+func insertLegHeaders(f *formatter.Formatter, content string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	visits := airportVisits(f, lines)
+	returnLine := returnLegStart(visits)
+	if returnLine < 0 {
+		return content, false
+	}
+
+	lastOutbound := -1
+	for _, visit := range visits {
+		if visit.line < returnLine {
+			lastOutbound = visit.line
+		}
+	}
+	outboundAt := sectionStart(lines, visits[0].line, -1)
+	returnAt := sectionStart(lines, returnLine, lastOutbound)
+
+	var out []string
+	for i, line := range lines {
+		switch i {
+		case outboundAt:
+			out = appendHeader(out, outboundHeader)
+		case returnAt:
+			out = appendHeader(out, returnHeader)
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n"), true
+}
+
+// appendHeader appends a header line set apart by blank lines.
+func appendHeader(lines []string, header string) []string {
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+		lines = append(lines, "")
+	}
+	return append(lines, header, "")
+}
+
+// noSummary is the -oneline summary of content without airports or dates.
+const noSummary = "No airports or dates found"
+
+// itinerarySummary condenses content processed by f into one line: the route
+// through the airports mentioned and the range of dates, e.g.
+// "LAX → SFO → JFK, 01–03 May 2023". Repeated mentions of an airport in a
+// row, such as an arrival followed by the next departure, appear once.
+func itinerarySummary(f *formatter.Formatter, content string) string {
+	var route []string
+	var previous *formatter.Airport
+	for _, visit := range airportVisits(f, strings.Split(content, "\n")) {
+		if visit.airport == previous {
+			continue
+		}
+		previous = visit.airport
+		code := visit.airport.IATACode
+		if code == "" {
+			code = visit.airport.ICAOCode
+		}
+		route = append(route, code)
+	}
+
+	var parts []string
+	if len(route) > 0 {
+		parts = append(parts, strings.Join(route, " → "))
+	}
+	if events := calendarEvents(content); len(events) > 0 {
+		first, last := events[0].start, events[0].start
+		for _, event := range events[1:] {
+			if event.start.Before(first) {
+				first = event.start
+			}
+			if event.start.After(last) {
+				last = event.start
+			}
+		}
+		parts = append(parts, dateRange(first, last))
+	}
+	if len(parts) == 0 {
+		return noSummary
+	}
+	return strings.Join(parts, ", ")
+}
+
+// dateRange formats the days from first to last as compactly as their shared
+// month and year allow, e.g. "01–03 May 2023" or "28 Apr – 03 May 2023".
+func dateRange(first, last time.Time) string {
+	switch {
+	case first.Format("20060102") == last.Format("20060102"):
+		return first.Format("02 Jan 2006")
+	case first.Year() == last.Year() && first.Month() == last.Month():
+		return first.Format("02") + "–" + last.Format("02 Jan 2006")
+	case first.Year() == last.Year():
+		return first.Format("02 Jan") + " – " + last.Format("02 Jan 2006")
+	}
+	return first.Format("02 Jan 2006") + " – " + last.Format("02 Jan 2006")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// airportDetails lists the fields printed by -lookup, in order.
+var airportDetails = []struct {
+	label string
+	field func(*formatter.Airport) string
+}{
+	{"Name", func(a *formatter.Airport) string { return a.Name }},
+	{"Display name", func(a *formatter.Airport) string { return a.DisplayName }},
+	{"City", func(a *formatter.Airport) string { return a.Municipality }},
+	{"Country", func(a *formatter.Airport) string { return a.ISOCountry }},
+	{"ICAO", func(a *formatter.Airport) string { return a.ICAOCode }},
+	{"IATA", func(a *formatter.Airport) string { return a.IATACode }},
+	{"Coordinates", func(a *formatter.Airport) string { return a.Coordinates }},
+}
+
+// printAirportLookup prints the airport f has for each code in the
+// comma-separated -lookup list. An unknown code is reported as an
+// error, with close known codes, and the others are still printed. It
+// returns the exit code: 1 if any code was unknown.
+func (c *config) printAirportLookup(f *formatter.Formatter) int {
+	status := 0
+	first := true
+	for _, code := range strings.Split(c.lookup, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		airport, exists := f.Lookup(code)
+		if !exists {
+			message := fmt.Sprintf("Unknown airport code %q", code)
+			if suggestions := f.SuggestCodes(code); len(suggestions) > 0 {
+				message += fmt.Sprintf("; did you mean %s?", strings.Join(suggestions, ", "))
+			}
+			c.printError(message)
+			status = 1
+			continue
+		}
+		if !first {
+			fmt.Fprintln(c.stdout)
+		}
+		first = false
+		fmt.Fprintln(c.stdout, code)
+		for _, detail := range airportDetails {
+			if value := detail.field(airport); value != "" || detail.label != "Display name" {
+				fmt.Fprintf(c.stdout, "  %-12s %s\n", detail.label+":", value)
+			}
+		}
+	}
+	return status
+}
@@ -1,450 +1,1000 @@
-package main
-
-import (
-	"encoding/csv"
-	"flag"
-	"fmt"
-	"os"
-	"regexp"
-	"strings"
-	"time"
-)
-
-// ANSI escape codes for terminal text formatting (used only in stdout)
-const (
-	ColorReset   = "\033[0m"
-	ColorRed     = "\033[31m"
-	ColorGreen   = "\033[32m"
-	ColorYellow  = "\033[33m"
-	ColorBlue    = "\033[34m"
-	ColorMagenta = "\033[35m"
-	ColorCyan    = "\033[36m"
-	Bold         = "\033[1m"
-	Italic       = "\033[3m"
-	Underline    = "\033[4m"
-)
-
-// Airport represents details of an airport.
-type Airport struct {
-	Name         string
-	ISOCountry   string
-	Municipality string // city name
-	ICAOCode     string
-	IATACode     string
-	Coordinates  string
-}
-
-// airportMap stores airport info using IATA or ICAO codes as keys.
-var airportMap map[string]*Airport
-
-func main() {
-	// Define a flag for displaying help.
-	helpFlag := flag.Bool("h", false, "Display usage information")
-	flag.Parse()
-
-	if *helpFlag {
-		printUsage()
-		return
-	}
-
-	// Get command-line arguments.
-	args := flag.Args()
-	if len(args) != 3 {
-		printUsage()
-		return
-	}
-
-	inputPath := args[0]
-	outputPath := args[1]
-	airportLookupPath := args[2]
-
-	if !fileExists(inputPath) {
-		printError("Input file not found")
-		return
-	}
-	if !fileExists(airportLookupPath) {
-		printError("Airport lookup file not found")
-		return
-	}
-
-	if err := loadAirportData(airportLookupPath); err != nil {
-		printError(fmt.Sprintf("Airport lookup file is malformed: %v", err))
-		return
-	}
-
-	input, err := os.ReadFile(inputPath)
-	if err != nil {
-		printError(fmt.Sprintf("Error reading input file: %v", err))
-		return
-	}
-
-	// Process the content in two ways:
-	// 1. Plain output for the file (no ANSI codes)
-	// 2. Highlighted output for the terminal
-	plainOutput := plainProcessContent(string(input))
-	highlightedOutput := highlightProcessContent(string(input))
-
-	// Write plain output to file.
-	if err := os.WriteFile(outputPath, []byte(plainOutput), 0644); err != nil {
-		printError(fmt.Sprintf("Error writing output file: %v", err))
-		return
-	}
-
-	printSuccess("Processing completed successfully!")
-
-	// Print highlighted output to stdout.
-	fmt.Printf("\n%s%s=== Processed Output ===%s\n\n", Bold, ColorBlue, ColorReset)
-	fmt.Println(highlightedOutput)
-}
-
-// printUsage prints the usage information.
-func printUsage() {
-	fmt.Printf("%s%sItinerary usage:%s\n", Bold, Underline, ColorReset)
-	fmt.Printf("%sgo run . ./input.txt ./output.txt ./airport-lookup.csv%s\n", Italic, ColorReset)
-}
-
-// fileExists checks if a file exists.
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
-}
-
-// loadAirportData loads airport data from a CSV into airportMap.
-// It supports non-standard CSV column order by using header names.
-func loadAirportData(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	header, err := reader.Read()
-	if err != nil {
-		return err
-	}
-
-	// Build a map from trimmed, lowercased header name to index.
-	requiredColumns := []string{"name", "iso_country", "municipality", "icao_code", "iata_code", "coordinates"}
-	columnMap := make(map[string]int)
-	for i, column := range header {
-		key := strings.TrimSpace(strings.ToLower(column))
-		columnMap[key] = i
-	}
-	// Debug: print the header mapping (remove if not needed)
-	// fmt.Printf("Header mapping: %#v\n", columnMap)
-
-	// Ensure all required columns exist.
-	for _, req := range requiredColumns {
-		if _, exists := columnMap[req]; !exists {
-			return fmt.Errorf("missing required column: %s", req)
-		}
-	}
-
-	airportMap = make(map[string]*Airport)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return err
-	}
-
-	for _, record := range records {
-		// Skip empty records.
-		if len(record) == 0 {
-			continue
-		}
-		if len(record) != len(header) {
-			return fmt.Errorf("malformed record")
-		}
-		name := record[columnMap["name"]]
-		iataCode := record[columnMap["iata_code"]]
-		icaoCode := record[columnMap["icao_code"]]
-
-		if strings.TrimSpace(name) == "" {
-			return fmt.Errorf("empty name in record")
-		}
-		if strings.TrimSpace(iataCode) == "" && strings.TrimSpace(icaoCode) == "" {
-			return fmt.Errorf("record has no IATA or ICAO code")
-		}
-
-		airport := &Airport{
-			Name:         name,
-			ISOCountry:   record[columnMap["iso_country"]],
-			Municipality: record[columnMap["municipality"]],
-			ICAOCode:     icaoCode,
-			IATACode:     iataCode,
-			Coordinates:  record[columnMap["coordinates"]],
-		}
-
-		// Map both IATA and ICAO codes.
-		if iataCode != "" {
-			airportMap[iataCode] = airport
-		}
-		if icaoCode != "" {
-			airportMap[icaoCode] = airport
-		}
-	}
-
-	return nil
-}
-
-
-// Plain (Non-Formatted) Processing Functions
-// Used for writing plain text to the output file.
-
-func plainProcessContent(content string) string {
-	content = plainProcessAirportCodes(content)
-	content = plainProcessDatesAndTimes(content)
-	content = trimHorizontalWhitespace(content)
-	content = trimVerticalWhitespace(content)
-	return content
-}
-
-// plainProcessAirportCodes replaces airport codes with plain text names or cities.
-// With "*" prefix it outputs the municipality.
-func plainProcessAirportCodes(content string) string {
-	// IATA codes: supports *#ABC
-	iataRegex := regexp.MustCompile(`(\*?)#([A-Z]{3})`)
-	content = iataRegex.ReplaceAllStringFunc(content, func(match string) string {
-		groups := iataRegex.FindStringSubmatch(match)
-		code := groups[2]
-		if airport, exists := airportMap[code]; exists {
-			if groups[1] == "*" {
-				return plainCity(airport)
-			}
-			return plainAirport(airport)
-		}
-		return match
-	})
-
-	// ICAO codes: supports *##ABCD
-	icaoRegex := regexp.MustCompile(`(\*?)##([A-Z]{4})`)
-	content = icaoRegex.ReplaceAllStringFunc(content, func(match string) string {
-		groups := icaoRegex.FindStringSubmatch(match)
-		code := groups[2]
-		if airport, exists := airportMap[code]; exists {
-			if groups[1] == "*" {
-				return plainCity(airport)
-			}
-			return plainAirport(airport)
-		}
-		return match
-	})
-	return content
-}
-
-// plainAirport returns the airport name.
-func plainAirport(airport *Airport) string {
-	return airport.Name
-}
-
-// plainCity returns the municipality (city) if available.
-func plainCity(airport *Airport) string {
-	if strings.TrimSpace(airport.Municipality) != "" {
-		return airport.Municipality
-	}
-	return plainAirport(airport)
-}
-
-// plainProcessDatesAndTimes replaces date/time placeholders with plain formatted dates/times.
-func plainProcessDatesAndTimes(content string) string {
-	// Dates: D(...)
-	dateRegex := regexp.MustCompile(`D\(([0-9T:.Z+-]{16,})\)`)
-	content = dateRegex.ReplaceAllStringFunc(content, func(match string) string {
-		dateStr := match[2 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", dateStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", dateStr)
-			if err != nil {
-				return match
-			}
-		}
-		return t.Format("02 Jan 2006")
-	})
-
-	// 12-hour time: T12(...)
-	time12Regex := regexp.MustCompile(`T12\(([0-9T:.Z+-]{16,})\)`)
-	content = time12Regex.ReplaceAllStringFunc(content, func(match string) string {
-		timeStr := match[4 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", timeStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", timeStr)
-			if err != nil {
-				return match
-			}
-		}
-		zone := t.Format("-07:00")
-		if zone == "Z" {
-			zone = "(+00:00)"
-		} else {
-			zone = fmt.Sprintf("(%s)", zone)
-		}
-		return fmt.Sprintf("%s %s", t.Format("03:04PM"), zone)
-	})
-
-	// 24-hour time: T24(...)
-	time24Regex := regexp.MustCompile(`T24\(([0-9T:.Z+-]{16,})\)`)
-	content = time24Regex.ReplaceAllStringFunc(content, func(match string) string {
-		timeStr := match[4 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", timeStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", timeStr)
-			if err != nil {
-				return match
-			}
-		}
-		zone := t.Format("-07:00")
-		if zone == "Z" {
-			zone = "(+00:00)"
-		} else {
-			zone = fmt.Sprintf("(%s)", zone)
-		}
-		return fmt.Sprintf("%s %s", t.Format("15:04"), zone)
-	})
-
-	return content
-}
-
-
-// Highlight (Colorized) Processing Functions
-// Used for printing to terminal with ANSI colors.
-
-func highlightProcessContent(content string) string {
-	content = processAirportCodes(content)
-	content = processDatesAndTimes(content)
-	content = trimHorizontalWhitespace(content)
-	content = trimVerticalWhitespace(content)
-	return content
-}
-
-// processAirportCodes replaces airport codes with highlighted airport names or cities.
-// With "*" prefix it outputs the municipality.
-func processAirportCodes(content string) string {
-	// IATA codes: supports *#ABC
-	iataRegex := regexp.MustCompile(`(\*?)#([A-Z]{3})`)
-	content = iataRegex.ReplaceAllStringFunc(content, func(match string) string {
-		groups := iataRegex.FindStringSubmatch(match)
-		code := groups[2]
-		if airport, exists := airportMap[code]; exists {
-			if groups[1] == "*" {
-				return highlightCity(airport)
-			}
-			return highlightAirport(airport)
-		}
-		return match
-	})
-
-	// ICAO codes: supports *##ABCD
-	icaoRegex := regexp.MustCompile(`(\*?)##([A-Z]{4})`)
-	content = icaoRegex.ReplaceAllStringFunc(content, func(match string) string {
-		groups := icaoRegex.FindStringSubmatch(match)
-		code := groups[2]
-		if airport, exists := airportMap[code]; exists {
-			if groups[1] == "*" {
-				return highlightCity(airport)
-			}
-			return highlightAirport(airport)
-		}
-		return match
-	})
-	return content
-}
-
-// highlightAirport returns the airport name highlighted in green.
-func highlightAirport(airport *Airport) string {
-	return fmt.Sprintf("%s%s%s", ColorGreen, airport.Name, ColorReset)
-}
-
-// highlightCity returns the municipality (city) highlighted in cyan.
-func highlightCity(airport *Airport) string {
-	if strings.TrimSpace(airport.Municipality) != "" {
-		return fmt.Sprintf("%s%s%s", ColorCyan, airport.Municipality, ColorReset)
-	}
-	return fmt.Sprintf("%s%s%s", ColorGreen, airport.Name, ColorReset)
-}
-
-// processDatesAndTimes replaces date/time placeholders with highlighted formatted dates/times.
-func processDatesAndTimes(content string) string {
-	// Dates: D(...)
-	dateRegex := regexp.MustCompile(`D\(([0-9T:.Z+-]{16,})\)`)
-	content = dateRegex.ReplaceAllStringFunc(content, func(match string) string {
-		dateStr := match[2 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", dateStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", dateStr)
-			if err != nil {
-				return match
-			}
-		}
-		return fmt.Sprintf("%s%s%s", ColorMagenta, t.Format("02 Jan 2006"), ColorReset)
-	})
-
-	// 12-hour time: T12(...)
-	time12Regex := regexp.MustCompile(`T12\(([0-9T:.Z+-]{16,})\)`)
-	content = time12Regex.ReplaceAllStringFunc(content, func(match string) string {
-		timeStr := match[4 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", timeStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", timeStr)
-			if err != nil {
-				return match
-			}
-		}
-		zone := t.Format("-07:00")
-		if zone == "Z" {
-			zone = "(+00:00)"
-		} else {
-			zone = fmt.Sprintf("(%s)", zone)
-		}
-		return fmt.Sprintf("%s%s%s %s%s%s", ColorCyan, t.Format("03:04PM"), ColorReset, ColorYellow, zone, ColorReset)
-	})
-
-	// 24-hour time: T24(...)
-	time24Regex := regexp.MustCompile(`T24\(([0-9T:.Z+-]{16,})\)`)
-	content = time24Regex.ReplaceAllStringFunc(content, func(match string) string {
-		timeStr := match[4 : len(match)-1]
-		t, err := time.Parse("2006-01-02T15:04Z", timeStr)
-		if err != nil {
-			t, err = time.Parse("2006-01-02T15:04-07:00", timeStr)
-			if err != nil {
-				return match
-			}
-		}
-		zone := t.Format("-07:00")
-		if zone == "Z" {
-			zone = "(+00:00)"
-		} else {
-			zone = fmt.Sprintf("(%s)", zone)
-		}
-		return fmt.Sprintf("%s%s%s %s%s%s", ColorCyan, t.Format("15:04"), ColorReset, ColorYellow, zone, ColorReset)
-	})
-
-	return content
-}
-
-// trimHorizontalWhitespace removes excessive horizontal whitespace.
-func trimHorizontalWhitespace(content string) string {
-	lines := strings.Split(content, "\n")
-	for i, line := range lines {
-		words := strings.Fields(line)
-		lines[i] = strings.Join(words, " ")
-	}
-	return strings.Join(lines, "\n")
-}
-
-// trimVerticalWhitespace removes excessive vertical whitespace.
-func trimVerticalWhitespace(content string) string {
-	content = regexp.MustCompile(`\\[rvf]`).ReplaceAllString(content, "\n")
-	content = regexp.MustCompile("[\\r\\v\\f]+").ReplaceAllString(content, "\n")
-	content = regexp.MustCompile("\n{3,}").ReplaceAllString(content, "\n\n")
-	return content
-}
-
-// printError prints an error message in red and bold.
-func printError(message string) {
-	fmt.Printf("%s%sError: %s%s\n", ColorRed, Bold, message, ColorReset)
-}
-
-// printSuccess prints a success message in green and bold.
-func printSuccess(message string) {
-	fmt.Printf("%s%sSuccess: %s%s\n", ColorGreen, Bold, message, ColorReset)
-}
+package main
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+	"golang.org/x/term"
+)
+
+// ANSI escape codes for terminal text formatting, written only where useColor allows
+const (
+	ColorReset   = "\033[0m"
+	ColorRed     = "\033[31m"
+	ColorGreen   = "\033[32m"
+	ColorYellow  = "\033[33m"
+	ColorBlue    = "\033[34m"
+	ColorMagenta = "\033[35m"
+	ColorCyan    = "\033[36m"
+	ColorGray    = "\033[90m"
+	Bold         = "\033[1m"
+	Italic       = "\033[3m"
+	Underline    = "\033[4m"
+)
+
+// defaultMaxFileSize is the largest input file read unless -max-file-size
+// says otherwise; it guards against passing the wrong file by mistake.
+const defaultMaxFileSize = 100 << 20
+
+// Result is the outcome of processing a document, as printed by -json-result.
+type Result struct {
+	Output     string         `json:"output"`
+	Unresolved []string       `json:"unresolved"`
+	Counts     map[string]int `json:"counts"` // placeholders replaced, by token type
+	Warnings   []string       `json:"warnings,omitempty"`
+}
+
+// newResult collects the rendered output and the counter's statistics.
+func newResult(f *formatter.Formatter, output string, counter *formatter.Counter) Result {
+	result := Result{
+		Output:     output,
+		Unresolved: counter.UnresolvedCodes(),
+		Counts:     make(map[string]int),
+		Warnings:   f.Warnings(counter),
+	}
+	for _, tokenType := range []string{formatter.TokenAirport, formatter.TokenDate, formatter.TokenTime} {
+		result.Counts[tokenType] = counter.Replaced(tokenType)
+	}
+	return result
+}
+
+// tokensJSON returns the placeholders found as an indented JSON document.
+func tokensJSON(tokens []formatter.DetectedToken) (string, error) {
+	if tokens == nil {
+		tokens = []formatter.DetectedToken{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Tokens []formatter.DetectedToken `json:"tokens"`
+	}{tokens}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// printJSONResult writes result to stdout as a single JSON object.
+func (c *config) printJSONResult(result Result) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(result)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the command-line arguments, processes the input, or each input
+// of a batch, and returns the process exit code: 0 on success and 1 on any
+// error, or 2 when the flags cannot be parsed.
+func run(args []string, stdout, stderr io.Writer) int {
+	c, err := parseConfig(args, stdout, stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errFlagSyntax):
+		return 2
+	case err != nil:
+		c.printError(err.Error())
+		if errors.As(err, new(usageError)) {
+			c.printUsage()
+		}
+		return 1
+	}
+
+	switch {
+	case c.showUsage:
+		c.printUsage()
+		return 0
+	case c.syntaxHelp:
+		c.printSyntaxHelp()
+		return 0
+	case c.pipeline:
+		fmt.Fprintln(c.stdout, (&formatter.Formatter{Options: c.opts}).Pipeline())
+		return 0
+	}
+
+	if c.cpuProfile != "" || c.memProfile != "" {
+		stopProfiling, err := c.startProfiling()
+		if err != nil {
+			c.printError(fmt.Sprintf("Error starting profiler: %v", err))
+			return 1
+		}
+		defer stopProfiling()
+	}
+
+	if c.dumpGrammar {
+		if err := c.printGrammar(&formatter.Formatter{Options: c.opts}); err != nil {
+			c.printError(fmt.Sprintf("Error encoding grammar: %v", err))
+			return 1
+		}
+		return 0
+	}
+
+	if c.logPath != "" {
+		closeLog, err := c.openRunLog(c.logPath)
+		if err != nil {
+			c.printError(fmt.Sprintf("Error opening log file: %v", err))
+			return 1
+		}
+		defer closeLog()
+		runStart := time.Now()
+		defer func() { c.logf("finished in %v", time.Since(runStart)) }()
+		c.logStart()
+	}
+
+	f, err := c.loadFormatter()
+	if err != nil {
+		c.printError(err.Error())
+		return 1
+	}
+	switch {
+	case c.lookup != "":
+		return c.printAirportLookup(f)
+	case c.batch:
+		return c.processBatch(f)
+	}
+	if err := c.processFile(f, c.inputPaths[0], c.outputPath); err != nil {
+		c.printError(err.Error())
+		return 1
+	}
+	return 0
+}
+
+// airportSource names the airport data for the run log.
+func (c *config) airportSource() string {
+	return cmp.Or(c.airportLookupPath, "embedded airport data")
+}
+
+// logStart records what the run is about to do in the run log.
+func (c *config) logStart() {
+	switch {
+	case c.lookup != "":
+		c.logf("started: lookup of %s, airport data %s", c.lookup, c.airportSource())
+	case c.batch:
+		c.logf("started: %d input file(s), airport data %s", len(c.inputPaths), c.airportSource())
+	default:
+		c.logf("started: input %s, output %s, airport data %s", c.inputPaths[0], cmp.Or(c.outputPath, "stdout"), c.airportSource())
+	}
+}
+
+// loadFormatter loads the airport data, once for every input file, and
+// returns a Formatter with the run's options and -add-airport airports.
+func (c *config) loadFormatter() (*formatter.Formatter, error) {
+	if len(c.inputPaths) == 1 && !c.batch && c.inputPaths[0] != "-" && !fileExists(c.inputPaths[0]) {
+		return nil, errors.New("Input file not found")
+	}
+	path := c.airportLookupPath
+	if path != "" && !isRemoteLookup(path) && !fileExists(path) {
+		return nil, errors.New("Airport lookup file not found")
+	}
+
+	loadStart := time.Now()
+	f, err := c.loadAirportData(path)
+	var fetchErr *fetchError
+	if errors.As(err, &fetchErr) {
+		return nil, fmt.Errorf("Error fetching airport lookup file: %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Airport lookup file is malformed: %v", err)
+	}
+	f.Options = c.opts
+	c.logf("loaded %d airport code(s) from %s", f.Len(), c.airportSource())
+	f.AddAirports(c.addedAirports)
+	if c.timing {
+		lookupSize := len(embeddedAirportData)
+		if isRemoteLookup(path) {
+			lookupSize = 0 // download size is not tracked
+		} else if info, err := os.Stat(path); err == nil {
+			lookupSize = int(info.Size())
+		}
+		c.printTiming("loading airport data", time.Since(loadStart), lookupSize)
+	}
+	return f, nil
+}
+
+// processBatch processes every input of a batch into its own output file. A
+// failed file is reported and the batch carries on with the next.
+func (c *config) processBatch(f *formatter.Formatter) int {
+	if c.outDir != "" && !c.dryRun {
+		if err := os.MkdirAll(c.outDir, 0755); err != nil {
+			c.printError(fmt.Sprintf("Error creating output directory: %v", err))
+			return 1
+		}
+	}
+	failed := 0
+	for _, inputPath := range c.inputPaths {
+		if err := c.processFile(f, inputPath, batchOutputPath(inputPath, c.outDir)); err != nil {
+			c.printError(fmt.Sprintf("%s: %v", inputPath, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		c.printError(fmt.Sprintf("%d of %d input file(s) failed", failed, len(c.inputPaths)))
+		return 1
+	}
+	return 0
+}
+
+// processFile processes one input file into outputPath. In a batch,
+// warnings name the input file and the processed output is not shown.
+func (c *config) processFile(f *formatter.Formatter, inputPath, outputPath string) error {
+	var warningPrefix string
+	if c.batch {
+		warningPrefix = inputPath + ": "
+		if !fileExists(inputPath) {
+			return errors.New("Input file not found")
+		}
+	}
+
+	content, err := c.readDocument(inputPath)
+	if err != nil {
+		return err
+	}
+
+	// Process the content once, then render it in two ways:
+	// 1. Plain output for the file (no ANSI codes), or one file per -formats entry
+	// 2. Highlighted output for the terminal
+	counter := formatter.NewCounter()
+	processed, err := c.expand(f, content, outputPath, warningPrefix, counter)
+	if err != nil {
+		return err
+	}
+	if codes := counter.UnresolvedCodes(); c.strict && len(codes) > 0 {
+		return fmt.Errorf("%d unresolved airport code(s):\n%s", len(codes), counter.UnresolvedReport())
+	}
+	if failures := checkRequirements(c.requirements, counter); len(failures) > 0 {
+		return fmt.Errorf("Document does not meet requirements: %s", strings.Join(failures, "; "))
+	}
+	if processed, err = c.addSections(f, processed, warningPrefix, counter); err != nil {
+		return err
+	}
+
+	// The output file may carry the warnings in a header; the terminal does not.
+	fileContent := processed
+	if c.embedWarnings {
+		fileContent = embedWarnings(f, processed, counter)
+	}
+
+	if c.jsonResult {
+		if err := c.printJSONResult(newResult(f, formatter.Render(fileContent, formatter.PlainRenderer{}), counter)); err != nil {
+			return fmt.Errorf("Error encoding result: %v", err)
+		}
+		return nil
+	}
+
+	if c.dryRun {
+		c.printDryRunSummary(strings.TrimSuffix(warningPrefix, ": "), counter, strings.Count(strings.TrimSuffix(content, "\n"), "\n")+1)
+	} else if err := c.writeDocument(content, fileContent, outputPath, counter); err != nil {
+		return err
+	}
+
+	for _, warning := range f.Warnings(counter) {
+		c.printWarning(warningPrefix + warning)
+	}
+	switch {
+	case c.batch && c.dryRun:
+		c.printSuccess(fmt.Sprintf("Dry run of %s completed; no files were written", inputPath))
+	case c.batch:
+		c.logf("wrote %s", outputPath)
+		c.printSuccess(fmt.Sprintf("Processed %s into %s", inputPath, outputPath))
+	case c.dryRun:
+		c.printSuccess("Dry run completed; no files were written")
+	default:
+		c.logf("wrote %s", cmp.Or(outputPath, "stdout"))
+		c.printSuccess("Processing completed successfully!")
+	}
+	if c.batch || (outputPath == "" && !c.dryRun) || c.format == "json" {
+		// The output went to a file of a batch or to stdout, or is not
+		// text to highlight.
+		return nil
+	}
+	c.showProcessed(f, content, processed)
+	return nil
+}
+
+// readDocument reads the input at inputPath, "-" for stdin, and expands its
+// @include directives.
+func (c *config) readDocument(inputPath string) (string, error) {
+	if c.maxFileSize > 0 && inputPath != "-" {
+		info, err := os.Stat(inputPath)
+		if err != nil {
+			return "", fmt.Errorf("Error reading input file: %v", err)
+		}
+		if info.Size() > c.maxFileSize {
+			return "", fmt.Errorf("Input file is %d bytes, larger than -max-file-size of %d bytes", info.Size(), c.maxFileSize)
+		}
+	}
+
+	input, err := readInput(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("Error reading input file: %v", err)
+	}
+	if c.maxFileSize > 0 && int64(len(input)) > c.maxFileSize {
+		return "", fmt.Errorf("Input is %d bytes, larger than -max-file-size of %d bytes", len(input), c.maxFileSize)
+	}
+
+	content, err := expandIncludes(string(input), inputPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("Error expanding includes: %v", err)
+	}
+	if formatter.UsesNamedZones(content) {
+		if err := formatter.CheckTimezoneDatabase(); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// expand expands the placeholders of content into counter: reusing the
+// output of unchanged lines with -base, in chunks with -parallel-lines, or
+// serially.
+func (c *config) expand(f *formatter.Formatter, content, outputPath, warningPrefix string, counter *formatter.Counter) (string, error) {
+	processStart := time.Now()
+	var processed string
+	var cache map[string]string
+	if c.base != "" {
+		base, previousOutput, exists, err := readBase(c.base, outputPath)
+		if err != nil {
+			return "", fmt.Errorf("Error reading -base input: %v", err)
+		}
+		switch {
+		case c.formats != "":
+			c.printWarning("-base cannot reuse -formats output; processing every line")
+		case !exists:
+			c.printWarning("no previous output to reuse; processing every line")
+		default:
+			if cache, err = c.baseOutputCache(f, base, previousOutput); err != nil {
+				c.printWarning(fmt.Sprintf("cannot reuse the previous output (%v); processing every line", err))
+			}
+		}
+	}
+	if cache != nil {
+		var reused int
+		processed, reused = f.ProcessIncremental(content, cache, counter)
+		c.logf("reused %d line(s) of the previous output", reused)
+	} else if c.parallelLines > 1 {
+		if err := parallelUnsupported(f); err != nil {
+			c.printWarning(fmt.Sprintf("%scannot process in parallel (%v); processing serially", warningPrefix, err))
+			processed = f.Process(content, counter)
+		} else {
+			processed = f.ProcessParallel(content, c.parallelLines, counter)
+		}
+	} else {
+		processed = f.Process(content, counter)
+	}
+	if c.timing {
+		c.printTiming("processing", time.Since(processStart), len(content))
+	}
+	c.logf("expanded %d airport(s), %d date(s), %d time(s); %d unresolved code(s)",
+		counter.Expanded(formatter.TokenAirport), counter.Expanded(formatter.TokenDate), counter.Expanded(formatter.TokenTime),
+		len(counter.UnresolvedCodes()))
+	for _, warning := range f.Warnings(counter) {
+		c.logf("warning: %s", warning)
+	}
+	return processed, nil
+}
+
+// addSections writes the -ics calendar and applies -oneline, -legs and
+// -appendix to the processed document.
+func (c *config) addSections(f *formatter.Formatter, processed, warningPrefix string, counter *formatter.Counter) (string, error) {
+	if c.ics != "" && !c.dryRun {
+		if err := os.WriteFile(c.ics, []byte(icsCalendar(calendarEvents(processed), f.ReferenceNow())), 0644); err != nil {
+			return "", fmt.Errorf("Error writing calendar file: %v", err)
+		}
+	}
+	if c.oneline {
+		processed = itinerarySummary(f, processed) + "\n"
+	}
+	if c.legs {
+		var found bool
+		if processed, found = insertLegHeaders(f, processed); !found {
+			c.printWarning(warningPrefix + "no round trip detected; leg headers not added")
+		}
+	}
+	if c.appendix {
+		processed += c.airportAppendix(counter)
+	}
+	return processed, nil
+}
+
+// writeDocument writes the output file, or one file per -formats entry, and
+// compares it with the -expect golden file. content is the input, for
+// -side-by-side.
+func (c *config) writeDocument(content, fileContent, outputPath string, counter *formatter.Counter) error {
+	switch {
+	case c.formats != "":
+		if err := c.writeFormats(fileContent, strings.Split(c.formats, ","), outputPath); err != nil {
+			return fmt.Errorf("Error writing output file: %v", err)
+		}
+		return nil
+	case c.format == "json":
+		data, err := tokensJSON(counter.Tokens())
+		if err != nil {
+			return fmt.Errorf("Error encoding tokens: %v", err)
+		}
+		if err := c.writeOutput(outputPath, data); err != nil {
+			return fmt.Errorf("Error writing output file: %v", err)
+		}
+		return nil
+	}
+
+	fileOutput := formatter.Render(fileContent, c.fileRenderer)
+	if c.sideBySide {
+		fileOutput = sideBySide(content, fileOutput)
+	}
+	if err := c.writeOutput(outputPath, fileOutput); err != nil {
+		return fmt.Errorf("Error writing output file: %v", err)
+	}
+	if c.expect != "" {
+		golden, err := os.ReadFile(c.expect)
+		if err != nil {
+			return fmt.Errorf("Error reading expected output: %v", err)
+		}
+		if diff := lineDiff(string(golden), fileOutput); diff != "" {
+			return fmt.Errorf("Output differs from %s:\n%s", c.expect, diff)
+		}
+	}
+	return nil
+}
+
+// showProcessed prints the processed document to stdout under a banner.
+// Colors are left out when stdout is not a terminal, unless forced with
+// -color.
+func (c *config) showProcessed(f *formatter.Formatter, content, processed string) {
+	var display formatter.Renderer = formatter.PlainRenderer{}
+	if c.useColor(c.stdout) {
+		display = highlightRenderer{f: f, config: c}
+	}
+	fmt.Fprintf(c.stdout, "\n%s\n\n", c.paint(c.stdout, c.colors.Title, banner("Processed Output", c.terminalWidth())))
+	if c.sideBySide {
+		fmt.Fprintln(c.stdout, sideBySide(content, formatter.Render(processed, formatter.PlainRenderer{})))
+	} else {
+		fmt.Fprintln(c.stdout, formatter.Render(processed, display))
+	}
+}
+
+// airportAppendix returns an "Airports Mentioned" section listing every
+// referenced airport grouped by country, or "" if none were referenced.
+func (c *config) airportAppendix(counter *formatter.Counter) string {
+	airports := counter.ReferencedAirports()
+	if len(airports) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nAirports Mentioned\n")
+	country := ""
+	for i, airport := range airports {
+		if i == 0 || airport.ISOCountry != country {
+			country = airport.ISOCountry
+			fmt.Fprintf(&b, "\n%s\n", country)
+		}
+		b.WriteString("- " + c.appendixEntry(airport) + "\n")
+	}
+	return b.String()
+}
+
+// airportColumns maps the names accepted by -columns to Airport fields.
+var airportColumns = map[string]func(*formatter.Airport) string{
+	"name":         func(a *formatter.Airport) string { return a.Name },
+	"display_name": func(a *formatter.Airport) string { return a.DisplayName },
+	"iata":         func(a *formatter.Airport) string { return a.IATACode },
+	"icao":         func(a *formatter.Airport) string { return a.ICAOCode },
+	"city":         func(a *formatter.Airport) string { return a.Municipality },
+	"country":      func(a *formatter.Airport) string { return a.ISOCountry },
+	"coordinates":  func(a *formatter.Airport) string { return a.Coordinates },
+}
+
+// parseColumns parses a comma-separated -columns list.
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, exists := airportColumns[column]; !exists {
+			valid := make([]string, 0, len(airportColumns))
+			for name := range airportColumns {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unknown column %q (valid columns: %s)", column, strings.Join(valid, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// appendixEntry formats an airport for the appendix from the -columns
+// fields: the first non-empty field, then the others in parentheses, e.g.
+// "John F Kennedy International Airport (JFK, KJFK)".
+func (c *config) appendixEntry(airport *formatter.Airport) string {
+	var fields []string
+	for _, column := range c.appendixColumns {
+		if value := airportColumns[column](airport); value != "" {
+			fields = append(fields, value)
+		}
+	}
+	if len(fields) <= 1 {
+		return strings.Join(fields, "")
+	}
+	return fmt.Sprintf("%s (%s)", fields[0], strings.Join(fields[1:], ", "))
+}
+
+// requirement is a minimum or maximum placeholder count, e.g. "dates>=1".
+type requirement struct {
+	name      string // as written, e.g. "dates"
+	tokenType string
+	op        string
+	value     int
+}
+
+// requirementTypes maps the names accepted by -require to token types.
+var requirementTypes = map[string]string{
+	"airports": formatter.TokenAirport,
+	"dates":    formatter.TokenDate,
+	"times":    formatter.TokenTime,
+}
+
+// requirementRegex matches one "type op number" requirement.
+var requirementRegex = regexp.MustCompile(`^\s*([a-z]+)\s*(>=|<=|==|=|>|<)\s*(\d+)\s*$`)
+
+// parseRequirements parses a comma-separated list of requirements such as
+// "dates>=1,airports>=2".
+func parseRequirements(spec string) ([]requirement, error) {
+	var requirements []requirement
+	if strings.TrimSpace(spec) == "" {
+		return requirements, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		groups := requirementRegex.FindStringSubmatch(part)
+		if groups == nil {
+			return nil, fmt.Errorf("malformed requirement %q", strings.TrimSpace(part))
+		}
+		tokenType, exists := requirementTypes[groups[1]]
+		if !exists {
+			return nil, fmt.Errorf("unknown placeholder type %q (expected airports, dates or times)", groups[1])
+		}
+		value, err := strconv.Atoi(groups[3])
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, requirement{name: groups[1], tokenType: tokenType, op: groups[2], value: value})
+	}
+	return requirements, nil
+}
+
+// checkRequirements returns a description of every requirement that the
+// placeholder counts in counter do not satisfy. Placeholders count when they
+// resolve, so a document of unknown codes does not meet "airports>=1".
+func checkRequirements(requirements []requirement, counter *formatter.Counter) []string {
+	var failures []string
+	for _, req := range requirements {
+		found := counter.Count(req.tokenType)
+		var ok bool
+		switch req.op {
+		case ">=":
+			ok = found >= req.value
+		case "<=":
+			ok = found <= req.value
+		case ">":
+			ok = found > req.value
+		case "<":
+			ok = found < req.value
+		default:
+			ok = found == req.value
+		}
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s%s%d (found %d)", req.name, req.op, req.value, found))
+		}
+	}
+	return failures
+}
+
+// embedWarnings prepends a "# WARNING:" comment line for every warning raised
+// while processing, including unresolved airport codes. Content is returned
+// unchanged when there is nothing to report.
+func embedWarnings(f *formatter.Formatter, content string, counter *formatter.Counter) string {
+	warnings := f.Warnings(counter)
+	if codes := counter.UnresolvedCodes(); len(codes) > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d code(s) not found: %s", len(codes), strings.Join(codes, ", ")))
+	}
+	if len(warnings) == 0 {
+		return content
+	}
+
+	var header strings.Builder
+	for _, warning := range warnings {
+		fmt.Fprintf(&header, "# WARNING: %s\n", warning)
+	}
+	return header.String() + "\n" + content
+}
+
+// envPrefix prefixes the environment variables that provide flag defaults.
+const envPrefix = "TEXTFMT_"
+
+// envName returns the environment variable for a flag, e.g. "max-dates"
+// becomes "TEXTFMT_MAX_DATES".
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvDefaults sets every flag that was not given on the command line
+// from its TEXTFMT_* environment variable, if present. Explicit flags always
+// take precedence over the environment.
+func applyEnvDefaults(flags *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+	return err
+}
+
+// printUsage prints the usage information.
+func (c *config) printUsage() {
+	fmt.Fprintln(c.stdout, c.paint(c.stdout, Bold+Underline, "Itinerary usage:"))
+	fmt.Fprintln(c.stdout, c.paint(c.stdout, Italic, "go run . ./input.txt [./output.txt [./airport-lookup.csv]]"))
+	fmt.Fprintln(c.stdout, c.paint(c.stdout, Italic, "go run . -batch [-airports ./airport-lookup.csv] ./trips/*.txt"))
+}
+
+// resolvePaths maps the positional arguments to the input, output and airport
+// lookup paths: "input", "input output" or "input output lookup". An empty
+// output path means stdout, and an empty lookup path the embedded airport
+// data. An input or output path of "-" stands for stdin or stdout.
+func resolvePaths(args []string) (inputPath, outputPath, airportLookupPath string, err error) {
+	switch len(args) {
+	case 1:
+		return args[0], "", "", nil
+	case 2:
+		return args[0], args[1], "", nil
+	case 3:
+		return args[0], args[1], args[2], nil
+	}
+	return "", "", "", fmt.Errorf("expected 1 to 3 arguments (input [output [airport lookup]]), got %d", len(args))
+}
+
+// readInput reads the input file, or stdin when path is "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// fileExists checks if a file exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+// parseLengthRange parses a code length such as "4" or a range such as
+// "3-5".
+func parseLengthRange(value string) (minLength, maxLength int, err error) {
+	low, high, isRange := strings.Cut(value, "-")
+	if !isRange {
+		high = low
+	}
+	if minLength, err = strconv.Atoi(strings.TrimSpace(low)); err != nil {
+		return 0, 0, fmt.Errorf("expected a number or a range such as 3-5")
+	}
+	if maxLength, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+		return 0, 0, fmt.Errorf("expected a number or a range such as 3-5")
+	}
+	if minLength < 3 || maxLength > 8 || minLength > maxLength {
+		return 0, 0, fmt.Errorf("lengths must be from 3 to 8, smallest first")
+	}
+	return minLength, maxLength, nil
+}
+
+// airportCodePattern matches a bare IATA or ICAO code.
+var airportCodePattern = regexp.MustCompile(`^[A-Z]{3,4}$`)
+
+// airportOverrides collects -add-airport values. It implements flag.Value so
+// the flag can be repeated.
+type airportOverrides []*formatter.Airport
+
+func (o *airportOverrides) String() string {
+	var values []string
+	for _, airport := range *o {
+		values = append(values, airport.IATACode+airport.ICAOCode+"="+airport.Name)
+	}
+	return strings.Join(values, "; ")
+}
+
+// Set parses "CODE=Name[,City[,Country]]", where CODE is a three-letter IATA
+// or four-letter ICAO code.
+func (o *airportOverrides) Set(value string) error {
+	code, fields, found := strings.Cut(value, "=")
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !found || !airportCodePattern.MatchString(code) {
+		return fmt.Errorf("expected CODE=Name[,City[,Country]], got %q", value)
+	}
+	parts := strings.Split(fields, ",")
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if len(parts) > 3 {
+		return fmt.Errorf("too many fields in %q; names cannot contain commas", value)
+	}
+	airport := &formatter.Airport{
+		Name:         strings.TrimSpace(parts[0]),
+		Municipality: strings.TrimSpace(parts[1]),
+		ISOCountry:   strings.TrimSpace(parts[2]),
+	}
+	if airport.Name == "" {
+		return fmt.Errorf("empty name in %q", value)
+	}
+	if len(code) == 3 {
+		airport.IATACode = code
+	} else {
+		airport.ICAOCode = code
+	}
+	*o = append(*o, airport)
+	return nil
+}
+
+// embeddedAirportData is the bundled airport database, used when no lookup
+// file is given.
+//
+//go:embed airport-lookup.csv
+var embeddedAirportData []byte
+
+// loadAirportData returns a Formatter for the airport data at path. Files
+// with a .json extension hold an array of airport objects; anything else is
+// read as CSV. An empty path loads the embedded database, and an HTTP or
+// HTTPS URL is downloaded. Files are read through the cache unless it is
+// disabled.
+func (c *config) loadAirportData(path string) (*formatter.Formatter, error) {
+	var airports []*formatter.Airport
+	var err error
+	switch {
+	case path == "":
+		airports, err = formatter.ReadAirportsCSV(bytes.NewReader(embeddedAirportData))
+	case isRemoteLookup(path):
+		airports, err = c.fetchAirportData(path)
+	case c.useAirportCache:
+		airports, err = c.readCachedAirports(path)
+	default:
+		airports, err = c.readAirportFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return formatter.NewFromAirports(airports)
+}
+
+// parseDelimiter parses a -delimiter value: a single character, or "tab".
+func parseDelimiter(value string) (rune, error) {
+	if value == "tab" || value == `\t` {
+		return '\t', nil
+	}
+	delimiter, size := utf8.DecodeRuneInString(value)
+	if size == 0 || size != len(value) || delimiter == '"' || delimiter == '\r' || delimiter == '\n' || delimiter == utf8.RuneError {
+		return 0, fmt.Errorf("expected a single character other than a quote or line break, or tab")
+	}
+	return delimiter, nil
+}
+
+// lookupDelimiter returns the field separator for the lookup file at path:
+// the -delimiter when set, otherwise a tab for .tsv, "|" for .psv and a
+// comma for anything else.
+func (c *config) lookupDelimiter(path string) rune {
+	if c.airportDelimiter != 0 {
+		return c.airportDelimiter
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv":
+		return '\t'
+	case ".psv":
+		return '|'
+	}
+	return ','
+}
+
+// readAirportFile reads airports from a JSON or delimited file.
+func (c *config) readAirportFile(path string) ([]*formatter.Airport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return formatter.ReadAirportsJSON(file)
+	}
+	return formatter.ReadAirportsDelimited(file, c.lookupDelimiter(path))
+}
+
+// maxIncludeDepth limits how deeply @include directives may nest.
+const maxIncludeDepth = 10
+
+// includeRegex matches an @include(path) directive.
+var includeRegex = regexp.MustCompile(`@include\(([^()\n]+)\)`)
+
+// expandIncludes replaces @include(path) directives in content with the
+// contents of the referenced files. Paths are resolved relative to the
+// directory of the including file at path and must stay within the
+// directory of the top-level file, so an input cannot pull in arbitrary
+// files. chain holds the files currently being expanded and is used to
+// detect include cycles.
+func expandIncludes(content, path string, chain []string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	for _, included := range chain {
+		if included == absPath {
+			return "", fmt.Errorf("include cycle detected: %s", strings.Join(append(chain, absPath), " -> "))
+		}
+	}
+	if len(chain) >= maxIncludeDepth {
+		return "", fmt.Errorf("include depth exceeds %d at %s", maxIncludeDepth, path)
+	}
+	chain = append(chain, absPath)
+
+	var expandErr error
+	content = includeRegex.ReplaceAllStringFunc(content, func(match string) string {
+		if expandErr != nil {
+			return match
+		}
+		written := strings.TrimSpace(includeRegex.FindStringSubmatch(match)[1])
+		includePath := written
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+		if !withinDir(filepath.Dir(chain[0]), includePath) {
+			expandErr = fmt.Errorf("%s in %s is outside the input file's directory", written, path)
+			return match
+		}
+		data, err := os.ReadFile(includePath)
+		if err != nil {
+			expandErr = err
+			return match
+		}
+		expanded, err := expandIncludes(string(data), includePath, chain)
+		if err != nil {
+			expandErr = err
+			return match
+		}
+		return expanded
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return content, nil
+}
+
+// withinDir reports whether path is dir or lies below it, with symbolic
+// links resolved where they exist.
+func withinDir(dir, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// openRunLog starts appending timestamped entries to the log file at path.
+// The returned function closes it.
+func (c *config) openRunLog(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.runLog = log.New(file, "", log.LstdFlags)
+	return func() {
+		c.runLog = nil
+		file.Close()
+	}, nil
+}
+
+// logf adds an entry to the run log, if one is open.
+func (c *config) logf(format string, args ...any) {
+	if c.runLog != nil {
+		c.runLog.Printf(format, args...)
+	}
+}
+
+// terminalWidth returns the width of the terminal on stdout, or 0 when stdout
+// is not a terminal or its size cannot be read.
+func (c *config) terminalWidth() int {
+	file, ok := c.stdout.(*os.File)
+	if !ok || !term.IsTerminal(int(file.Fd())) {
+		return 0
+	}
+	width, _, err := term.GetSize(int(file.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+// banner returns title between runs of "=" that fill width, e.g.
+// "=== Processed Output ===" when width is too small to fill.
+func banner(title string, width int) string {
+	fill := width - utf8.RuneCountInString(title) - 2
+	if fill < 6 {
+		return "=== " + title + " ==="
+	}
+	return strings.Repeat("=", fill/2) + " " + title + " " + strings.Repeat("=", fill-fill/2)
+}
+
+// printError prints an error message in the theme's error color, red and
+// bold by default.
+func (c *config) printError(message string) {
+	c.logf("error: %s", message)
+	fmt.Fprintln(c.status, c.paint(c.status, c.colors.Error, "Error: "+message))
+}
+
+// printDryRunSummary prints what processing changed for -dry-run: how many
+// placeholders of each type were replaced, and on how many of the input's
+// lines. A name, given in a batch, says which input file it was.
+func (c *config) printDryRunSummary(name string, counter *formatter.Counter, lines int) {
+	if name != "" {
+		fmt.Fprintln(c.status, c.paint(c.status, Bold, "Dry run of "+name+":"))
+	} else {
+		fmt.Fprintln(c.status, c.paint(c.status, Bold, "Dry run:"))
+	}
+	fmt.Fprintf(c.status, "  airport codes resolved: %d\n", counter.Replaced(formatter.TokenAirport))
+	fmt.Fprintf(c.status, "  dates formatted:        %d\n", counter.Replaced(formatter.TokenDate))
+	fmt.Fprintf(c.status, "  times formatted:        %d\n", counter.Replaced(formatter.TokenTime))
+	fmt.Fprintf(c.status, "  lines affected:         %d of %d\n", counter.ReplacedLines(), lines)
+}
+
+// printWarning prints a warning message in the theme's warning color,
+// yellow by default.
+func (c *config) printWarning(message string) {
+	fmt.Fprintln(c.status, c.paint(c.status, c.colors.Warning, "Warning: "+message))
+}
+
+// printSuccess prints a success message in the theme's success color, green
+// and bold by default.
+func (c *config) printSuccess(message string) {
+	fmt.Fprintln(c.status, c.paint(c.status, c.colors.Success, "Success: "+message))
+}
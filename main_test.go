@@ -0,0 +1,1917 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// testAirportsCSV is a small airport table in the OurAirports column layout.
+const testAirportsCSV = `name,iso_country,municipality,icao_code,iata_code,coordinates
+Los Angeles International Airport,US,Los Angeles,KLAX,LAX,"-118.408, 33.9425"
+John F Kennedy International Airport,US,New York,KJFK,JFK,"-73.7789, 40.6398"
+Charles de Gaulle International Airport,FR,Paris,LFPG,CDG,"2.55, 49.012779"
+London Heathrow Airport,GB,London,EGLL,LHR,"-0.461941, 51.4706"
+`
+
+// writeFile writes content to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readFile returns the contents of the file at path.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// runCLI runs the command with args and returns what it printed and its
+// exit code.
+func runCLI(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut strings.Builder
+	code = run(args, &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// testConfig returns a config with the default settings that discards
+// everything it prints.
+func testConfig() *config {
+	return newConfig(io.Discard, io.Discard)
+}
+
+// loadTestAirports returns a Formatter for the airports of testAirportsCSV.
+func loadTestAirports(t *testing.T) *formatter.Formatter {
+	t.Helper()
+	c := testConfig()
+	c.useAirportCache = false
+	f, err := c.loadAirportData(writeFile(t, t.TempDir(), "airports.csv", testAirportsCSV))
+	if err != nil {
+		t.Fatalf("loadAirportData: %v", err)
+	}
+	return f
+}
+
+// set assigns v to *p until the test ends.
+func set[T any](t testing.TB, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+func TestExpandIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "footer.txt", "Contact: #LAX desk")
+	input := writeFile(t, dir, "trip.txt", "Trip\n@include(footer.txt)\n")
+
+	got, err := expandIncludes(readFile(t, input), input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Trip\nContact: #LAX desk\n"; got != want {
+		t.Errorf("expandIncludes = %q, want %q", got, want)
+	}
+}
+
+func TestExpandIncludesCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.txt", "A @include(b.txt)")
+	writeFile(t, dir, "b.txt", "B @include(a.txt)")
+	input := writeFile(t, dir, "trip.txt", "@include(a.txt)")
+
+	_, err := expandIncludes(readFile(t, input), input, nil)
+	if err == nil || !strings.Contains(err.Error(), "include cycle detected") {
+		t.Errorf("expandIncludes error = %v, want an include cycle", err)
+	}
+}
+
+func TestExpandIncludesMissingFile(t *testing.T) {
+	input := writeFile(t, t.TempDir(), "trip.txt", "@include(missing.txt)")
+	if _, err := expandIncludes(readFile(t, input), input, nil); err == nil {
+		t.Error("expandIncludes of a missing file succeeded")
+	}
+}
+
+func TestExpandIncludesOutsideDir(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "trip")
+	if err := os.MkdirAll(filepath.Join(dir, "parts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := writeFile(t, root, "secret.txt", "secret")
+	writeFile(t, dir, "parts/footer.txt", "footer")
+	if err := os.Symlink(secret, filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	input := writeFile(t, dir, "ok.txt", "@include(parts/footer.txt)")
+	if got, err := expandIncludes(readFile(t, input), input, nil); err != nil || got != "footer" {
+		t.Errorf("expandIncludes of a subdirectory = %q, %v, want footer", got, err)
+	}
+	for _, path := range []string{"../secret.txt", secret, "link.txt", "parts/../../secret.txt"} {
+		input := writeFile(t, dir, "trip.txt", "@include("+path+")")
+		_, err := expandIncludes(readFile(t, input), input, nil)
+		if err == nil || !strings.Contains(err.Error(), "is outside the input file's directory") {
+			t.Errorf("expandIncludes of %s error = %v, want outside the input file's directory", path, err)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	f := loadTestAirports(t)
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "site")
+	processed := f.Process("From #LAX to *#CDG on D(2023-05-01T10:00Z) <a&b>", formatter.NewCounter())
+
+	c := testConfig()
+	c.outDir = outDir
+	if err := c.writeFormats(processed, []string{"plain", " html", "markdown"}, filepath.Join(dir, "trip.out")); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"trip.txt":  "From Los Angeles International Airport to Paris on 01 May 2023 <a&b>",
+		"trip.html": `From <span class="airport">Los Angeles International Airport</span> to <span class="city">Paris</span> on <span class="date">01 May 2023</span> &lt;a&amp;b&gt;`,
+		"trip.md":   "From **Los Angeles International Airport** to *Paris* on `01 May 2023` \\<a&b\\>",
+	}
+	for name, content := range want {
+		if got := readFile(t, filepath.Join(outDir, name)); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if fileExists(filepath.Join(dir, "trip.out")) {
+		t.Error("writeFormats also wrote the output file itself")
+	}
+}
+
+func TestWriteFormatsUnknown(t *testing.T) {
+	dir := t.TempDir()
+	err := testConfig().writeFormats("#LAX", []string{"plain", "pdf"}, filepath.Join(dir, "trip.out"))
+	if err == nil || !strings.Contains(err.Error(), `unknown output format "pdf"`) {
+		t.Errorf("writeFormats error = %v, want an unknown output format", err)
+	}
+	if fileExists(filepath.Join(dir, "trip.txt")) {
+		t.Error("writeFormats wrote a file before rejecting the format list")
+	}
+}
+
+func TestEmbedWarnings(t *testing.T) {
+	f := loadTestAirports(t)
+	f.Limits[formatter.TokenDate] = 1
+	tests := []struct {
+		name, input, want string
+	}{
+		{"unresolved codes", "From #LAX to #XYZ and #QQQ and ##ZZZZ",
+			"# WARNING: 3 code(s) not found: QQQ, XYZ, ZZZZ\n\nFrom Los Angeles International Airport to #XYZ and #QQQ and ##ZZZZ"},
+		{"limit reached", "D(2023-05-01T10:00Z) D(2023-05-02T10:00Z)",
+			"# WARNING: date limit of 1 reached; 1 placeholder(s) left unexpanded\n\n01 May 2023 D(2023-05-02T10:00Z)"},
+		{"no warnings", "From #LAX to #JFK",
+			"From Los Angeles International Airport to John F Kennedy International Airport"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := formatter.NewCounter()
+			processed := f.Process(tt.input, counter)
+			if got := formatter.Render(embedWarnings(f, processed, counter), formatter.PlainRenderer{}); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		replace bool
+		want    string
+		wantErr bool
+	}{
+		{name: "ascii", text: "Departure: Los Angeles International Airport", want: "Departure: Los Angeles International Airport"},
+		{name: "latin-1", text: "Café à Orly", want: "Caf\xe9 \xe0 Orly"},
+		{name: "unmappable", text: "Fare: 20 €", wantErr: true},
+		{name: "unmappable replaced", text: "Fare: 20 €", replace: true, want: "Fare: 20 \x1a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.outputEncoding = "iso-8859-1"
+			c.replaceUnmappable = tt.replace
+			got, err := c.encodeOutput(tt.text)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("encodeOutput(%q) = %q, want an error", tt.text, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encodeOutput(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutputEncodingError(t *testing.T) {
+	c := testConfig()
+	c.outputEncoding = "iso-8859-1"
+	output := writeFile(t, t.TempDir(), "out.txt", "previous")
+	if err := c.writeOutput(output, "Fare: 20 €"); err == nil || !strings.Contains(err.Error(), "cannot encode output as iso-8859-1") {
+		t.Errorf("writeOutput error = %v, want an encoding error", err)
+	}
+	if got := readFile(t, output); got != "previous" {
+		t.Errorf("output after a failed encoding = %q, want it unchanged", got)
+	}
+}
+
+func TestLookupEncoding(t *testing.T) {
+	for _, name := range []string{"iso-8859-1", "windows-1252", "Shift_JIS"} {
+		if _, err := lookupEncoding(name); err != nil {
+			t.Errorf("lookupEncoding(%q): %v", name, err)
+		}
+	}
+	if _, err := lookupEncoding("no-such-encoding"); err == nil {
+		t.Error("lookupEncoding of an unknown name succeeded")
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	f := loadTestAirports(t)
+	dir := t.TempDir()
+	c := testConfig()
+	c.cpuProfile, c.memProfile = filepath.Join(dir, "cpu.pprof"), filepath.Join(dir, "mem.pprof")
+
+	stop, err := c.startProfiling()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.FormatPlain(strings.Repeat("From #LAX on D(2023-05-01T10:00Z)\n", 100))
+	stop()
+	stop()
+	for _, path := range []string{c.cpuProfile, c.memProfile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("%s was not written (%v)", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestAirportAppendix(t *testing.T) {
+	f := loadTestAirports(t)
+	counter := formatter.NewCounter()
+	f.Process("Trip: #LHR, #JFK, *#CDG, ##KLAX and #[JFK,ZZZ] again", counter)
+	const want = `
+
+Airports Mentioned
+
+FR
+- Charles de Gaulle International Airport (CDG, LFPG)
+
+GB
+- London Heathrow Airport (LHR, EGLL)
+
+US
+- John F Kennedy International Airport (JFK, KJFK)
+- Los Angeles International Airport (LAX, KLAX)
+`
+	if got := testConfig().airportAppendix(counter); got != want {
+		t.Errorf("airportAppendix = %q, want %q", got, want)
+	}
+}
+
+func TestAirportAppendixWithoutAirports(t *testing.T) {
+	f := loadTestAirports(t)
+	counter := formatter.NewCounter()
+	f.Process("Departs D(2023-05-01T10:00Z) from #ZZZ", counter)
+	if got := testConfig().airportAppendix(counter); got != "" {
+		t.Errorf("airportAppendix = %q, want it empty", got)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	tests := []struct {
+		name, original, processed, want string
+	}{
+		{
+			name:      "equal line counts",
+			original:  "#LAX\nD(2023-05-01T10:00Z)\n",
+			processed: "Los Angeles International Airport\n01 May 2023\n",
+			want:      "#LAX                 | Los Angeles International Airport\nD(2023-05-01T10:00Z) | 01 May 2023\n",
+		},
+		{
+			name:      "blank lines collapsed",
+			original:  "a\n\n\n\nb",
+			processed: "a\n\nb",
+			want:      "a | a\n  |\n  | b\n  |\nb |\n",
+		},
+		{
+			name:      "more processed lines",
+			original:  "a\\vb",
+			processed: "a\nb",
+			want:      "a\\vb | a\n     | b\n",
+		},
+		{
+			name:      "padding counts runes",
+			original:  "Café #CDG\nx",
+			processed: "Café Charles de Gaulle International Airport\nx",
+			want:      "Café #CDG | Café Charles de Gaulle International Airport\nx         | x\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sideBySide(tt.original, tt.processed); got != tt.want {
+				t.Errorf("sideBySide = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAirportLink(t *testing.T) {
+	const template = "https://example.com/airports/{code}?name={name}"
+	tests := []struct {
+		name  string
+		value formatter.Value
+		want  string
+	}{
+		{"airport", formatter.Value{Kind: formatter.ValueAirport, Text: "Paris", Code: "CDG"}, "https://example.com/airports/CDG?name=Paris"},
+		{"city", formatter.Value{Kind: formatter.ValueCity, Text: "Paris", Code: "LFPG"}, "https://example.com/airports/LFPG?name=Paris"},
+		{"name escaped", formatter.Value{Kind: formatter.ValueAirport, Text: "John F Kennedy/JFK", Code: "JFK"}, "https://example.com/airports/JFK?name=John%20F%20Kennedy%2FJFK"},
+		{"no code", formatter.Value{Kind: formatter.ValueAirport, Text: "Paris"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := airportLink(tt.value, template); got != tt.want {
+				t.Errorf("airportLink = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := airportLink(tests[0].value, ""); got != "" {
+		t.Errorf("airportLink without a template = %q, want \"\"", got)
+	}
+}
+
+func TestLinkTemplate(t *testing.T) {
+	f := loadTestAirports(t)
+	const template = "https://example.com/{code}/{name}"
+	processed := f.Process("From #LAX to *#CDG & back on D(2023-05-01T10:00Z)", formatter.NewCounter())
+	tests := []struct {
+		r    formatter.Renderer
+		want string
+	}{
+		{htmlRenderer{linkTemplate: template}, `From <a href="https://example.com/LAX/Los%20Angeles%20International%20Airport"><span class="airport">Los Angeles International Airport</span></a> to <a href="https://example.com/CDG/Paris"><span class="city">Paris</span></a> &amp; back on <span class="date">01 May 2023</span>`},
+		{markdownRenderer{linkTemplate: template}, "From [Los Angeles International Airport](https://example.com/LAX/Los%20Angeles%20International%20Airport) to [Paris](https://example.com/CDG/Paris) & back on `01 May 2023`"},
+		{formatter.PlainRenderer{}, "From Los Angeles International Airport to Paris & back on 01 May 2023"},
+	}
+	for _, tt := range tests {
+		if got := formatter.Render(processed, tt.r); got != tt.want {
+			t.Errorf("%T: rendered = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownLinkEscaping(t *testing.T) {
+	value := formatter.Value{Kind: formatter.ValueAirport, Text: "Charles_de_Gaulle [CDG]", Code: "CDG"}
+	if got, want := (markdownRenderer{linkTemplate: "https://example.com/wiki/{code}_(airport)"}).Value(value), `[Charles\_de\_Gaulle \[CDG\]](https://example.com/wiki/CDG_%28airport%29)`; got != want {
+		t.Errorf("Value = %q, want %q", got, want)
+	}
+}
+
+func TestMapLinkHTML(t *testing.T) {
+	f := loadTestAirports(t)
+	f.MapURLTemplate = "https://www.openstreetmap.org/?mlat={lat}&mlon={lon}"
+	processed := f.Process("#mapEGLL", formatter.NewCounter())
+	if got, want := formatter.Render(processed, htmlRenderer{}), `<a class="map" href="https://www.openstreetmap.org/?mlat=51.4706&amp;mlon=-0.461941">https://www.openstreetmap.org/?mlat=51.4706&amp;mlon=-0.461941</a>`; got != want {
+		t.Errorf("HTML = %q, want %q", got, want)
+	}
+}
+
+// testFlagSet returns a flag set with an -annotate and a -list-separator
+// flag parsed from args.
+func testFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *bool, *string) {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	annotate := flags.Bool("annotate", false, "")
+	separator := flags.String("list-separator", ", ", "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags, annotate, separator
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig([]string{"-no-cache", "-delimiter", "tab", "-color", "-strict", "trip.txt", "-", "airports.tsv"}, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.inputPaths, []string{"trip.txt"}) || c.outputPath != "" || c.airportLookupPath != "airports.tsv" {
+		t.Errorf("paths = %q, %q, %q, want trip.txt, stdout and airports.tsv", c.inputPaths, c.outputPath, c.airportLookupPath)
+	}
+	if c.useAirportCache || c.airportDelimiter != '\t' || !c.forceColor || !c.strict {
+		t.Errorf("config = %+v, want -no-cache, -delimiter, -color and -strict applied", c)
+	}
+
+	// A dry run writes no cache even without -no-cache.
+	if c, err = parseConfig([]string{"-dry-run", "trip.txt"}, io.Discard, io.Discard); err != nil || c.useAirportCache {
+		t.Errorf("-dry-run: useAirportCache = %v, err %v, want false", c.useAirportCache, err)
+	}
+
+	// Errors are returned with the config so they can be reported.
+	c, err = parseConfig([]string{"-batch", "-ics", "trip.ics", "trip.txt"}, io.Discard, io.Discard)
+	if c == nil || err == nil || err.Error() != "-ics cannot be combined with -batch" {
+		t.Errorf("parseConfig = %v, %v, want a config and the -ics conflict", c, err)
+	}
+}
+
+func TestEnvDefaults(t *testing.T) {
+	t.Setenv("TEXTFMT_ANNOTATE", "true")
+	t.Setenv("TEXTFMT_LIST_SEPARATOR", " / ")
+
+	flags, annotate, separator := testFlagSet(t)
+	if err := applyEnvDefaults(flags); err != nil {
+		t.Fatal(err)
+	}
+	if !*annotate || *separator != " / " {
+		t.Errorf("annotate = %v, list-separator = %q, want the environment defaults", *annotate, *separator)
+	}
+
+	// Flags given on the command line win over the environment.
+	flags, annotate, separator = testFlagSet(t, "-annotate=false", "-list-separator", "; ")
+	if err := applyEnvDefaults(flags); err != nil {
+		t.Fatal(err)
+	}
+	if *annotate || *separator != "; " {
+		t.Errorf("annotate = %v, list-separator = %q, want the flag values", *annotate, *separator)
+	}
+}
+
+func TestEnvDefaultsInvalid(t *testing.T) {
+	t.Setenv("TEXTFMT_ANNOTATE", "maybe")
+	flags, _, _ := testFlagSet(t)
+	if err := applyEnvDefaults(flags); err == nil || !strings.Contains(err.Error(), "invalid TEXTFMT_ANNOTATE") {
+		t.Errorf("applyEnvDefaults error = %v, want invalid TEXTFMT_ANNOTATE", err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got, want := envName("max-dates"), "TEXTFMT_MAX_DATES"; got != want {
+		t.Errorf("envName = %q, want %q", got, want)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	f := loadTestAirports(t)
+	requirements, err := parseRequirements("airports>=2,dates>=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := formatter.NewCounter()
+	f.Process("#LAX to #JFK on D(2023-05-01T10:00Z)", counter)
+	if failures := checkRequirements(requirements, counter); len(failures) != 0 {
+		t.Errorf("checkRequirements = %q, want none", failures)
+	}
+
+	counter = formatter.NewCounter()
+	f.Process("#LAX on T24(2023-05-01T10:00Z)", counter)
+	want := []string{"airports>=2 (found 1)", "dates>=1 (found 0)"}
+	if failures := checkRequirements(requirements, counter); !slices.Equal(failures, want) {
+		t.Errorf("checkRequirements = %q, want %q", failures, want)
+	}
+
+	// Unknown codes and malformed timestamps are not counted.
+	counter = formatter.NewCounter()
+	f.Process("#LAX to #ZZZ on D(2023-13-40T10:00Z)", counter)
+	if failures := checkRequirements(requirements, counter); !slices.Equal(failures, want) {
+		t.Errorf("checkRequirements with unresolved placeholders = %q, want %q", failures, want)
+	}
+}
+
+func TestParseRequirements(t *testing.T) {
+	requirements, err := parseRequirements(" dates >= 1, airports<3,times==0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requirements) != 3 || requirements[1] != (requirement{name: "airports", tokenType: formatter.TokenAirport, op: "<", value: 3}) {
+		t.Errorf("parseRequirements = %+v", requirements)
+	}
+	for spec, want := range map[string]string{
+		"dates>=":    `malformed requirement "dates>="`,
+		"cities>=1":  `unknown placeholder type "cities"`,
+		"dates>=1,,": `malformed requirement ""`,
+	} {
+		if _, err := parseRequirements(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseRequirements(%q) error = %v, want %s", spec, err, want)
+		}
+	}
+}
+
+func TestPrintTiming(t *testing.T) {
+	tests := []struct {
+		elapsed time.Duration
+		bytes   int
+		want    string
+	}{
+		{2 * time.Second, 3 << 20, "Timing: processing took 2s (1.50 MB/s)\n"},
+		{1500 * time.Millisecond, 0, "Timing: processing took 1.5s\n"},
+		{0, 1 << 20, "Timing: processing took 0s\n"},
+	}
+	for _, tt := range tests {
+		var stderr strings.Builder
+		newConfig(io.Discard, &stderr).printTiming("processing", tt.elapsed, tt.bytes)
+		if got := stderr.String(); got != tt.want {
+			t.Errorf("printTiming(%v, %d) wrote %q, want %q", tt.elapsed, tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	_, stderr, code := runCLI(t, "-max-file-size", "5", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, "Input file is 10 bytes, larger than -max-file-size of 5 bytes") {
+		t.Errorf("exit code %d, stderr %q, want the file refused", code, stderr)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output written for a refused input: %v", err)
+	}
+
+	for _, size := range []string{"10", "0"} {
+		if _, stderr, code := runCLI(t, "-max-file-size", size, input, output, lookup); code != 0 {
+			t.Errorf("-max-file-size %s: exit code %d: %s", size, code, stderr)
+		}
+	}
+	if got := readFile(t, output); got != "From Los Angeles International Airport\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestNowFlag(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "Departs DREL(2023-05-03T10:00Z)\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	if _, stderr, code := runCLI(t, "-now", "2023-05-01T10:00Z", input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "Departs in 2 days\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	_, stderr, code := runCLI(t, "-now", "yesterday", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, `Invalid -now "yesterday"`) {
+		t.Errorf("exit code %d, stderr %q, want -now rejected", code, stderr)
+	}
+}
+
+func TestStatusStream(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX to #JFK\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	stdout, stderr, code := runCLI(t, "-max-airports", "1", input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if strings.Contains(stdout, "Warning: ") || strings.Contains(stdout, "Success: ") {
+		t.Errorf("stdout = %q, want no status messages", stdout)
+	}
+	if !strings.Contains(stderr, "Warning: ") || !strings.Contains(stderr, "Success: ") {
+		t.Errorf("stderr = %q, want the warning and success messages", stderr)
+	}
+
+	stdout, stderr, code = runCLI(t, "-max-airports", "1", "-status-stream", "stdout", input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stdout)
+	}
+	if stderr != "" || !strings.Contains(stdout, "Warning: ") || !strings.Contains(stdout, "Success: ") {
+		t.Errorf("stdout = %q, stderr = %q, want the messages on stdout only", stdout, stderr)
+	}
+
+	_, stderr, code = runCLI(t, "-status-stream", "stdlog", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, `Invalid -status-stream "stdlog"`) {
+		t.Errorf("exit code %d, stderr %q, want the stream rejected", code, stderr)
+	}
+}
+
+func TestColorByCountry(t *testing.T) {
+	f := loadTestAirports(t)
+	c := testConfig()
+	c.colorByCountry = true
+	color := func(code string) string {
+		value := highlightRenderer{f, c}.Value(formatter.Value{Kind: formatter.ValueAirport, Text: "Airport", Code: code})
+		return strings.TrimSuffix(value, "Airport"+ColorReset)
+	}
+
+	if color("LAX") != color("JFK") || color("LAX") != color("KJFK") {
+		t.Errorf("LAX, JFK and KJFK colored %q, %q and %q, want one color for the US", color("LAX"), color("JFK"), color("KJFK"))
+	}
+	if color("LAX") == color("CDG") {
+		t.Errorf("US and FR airports both colored %q", color("LAX"))
+	}
+	if got := color("ZZZ"); got != ColorGreen {
+		t.Errorf("unknown airport colored %q, want %q", got, ColorGreen)
+	}
+
+	c.colorByCountry = false
+	if got := color("CDG"); got != ColorGreen {
+		t.Errorf("without colorByCountry CDG colored %q, want %q", got, ColorGreen)
+	}
+}
+
+func TestColorByCountryOrder(t *testing.T) {
+	// DE and IT, and JP and CA, hash to the same palette entry, so colors
+	// derived from the country code alone would collide.
+	f, err := formatter.NewFromAirports([]*formatter.Airport{
+		{Name: "Frankfurt Airport", ISOCountry: "DE", IATACode: "FRA"},
+		{Name: "Rome Fiumicino Airport", ISOCountry: "IT", IATACode: "FCO"},
+		{Name: "Narita International Airport", ISOCountry: "JP", IATACode: "NRT"},
+		{Name: "Toronto Pearson International Airport", ISOCountry: "CA", IATACode: "YYZ"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testConfig()
+	c.colorByCountry = true
+	r := highlightRenderer{f, c}
+	tests := []struct {
+		code  string
+		color int // index into countryPalette
+	}{
+		{"FCO", 0}, {"FRA", 1}, {"YYZ", 2}, {"NRT", 3}, {"FCO", 0},
+	}
+	for _, tt := range tests {
+		if got, want := r.countryColor(tt.code), countryPalette[tt.color]; got != want {
+			t.Errorf("%s colored %q, want %q", tt.code, got, want)
+		}
+	}
+}
+
+func TestHelpSyntax(t *testing.T) {
+	stdout, _, code := runCLI(t, "-help-syntax")
+	if code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	f, err := formatter.NewFromAirports(formatter.ExampleAirports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range f.Placeholders() {
+		if !strings.Contains(stdout, spec.Syntax+"  "+spec.Help+"\n") {
+			t.Errorf("output lacks %s", spec.Syntax)
+		}
+	}
+	if strings.Contains(stdout, "\033[") {
+		t.Errorf("output to a file has ANSI colors:\n%s", stdout)
+	}
+	for _, want := range []string{
+		"Los Angeles International Airport",
+		"Paris",
+		"15/03",
+		"Saturday, 15 March 2025",
+		"02:30PM",
+		"in 3 days",
+		"https://maps.google.com/?q=33.9425,-118.408",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("output lacks the expansion %q:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestHelpSyntaxColor(t *testing.T) {
+	stdout, _, code := runCLI(t, "-color", "-help-syntax")
+	if code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if want := Bold + Underline + "Placeholder syntax:" + ColorReset; !strings.Contains(stdout, want) {
+		t.Errorf("output lacks the colored heading %q:\n%s", want, stdout)
+	}
+}
+
+func TestICSCalendar(t *testing.T) {
+	f := loadTestAirports(t)
+	processed := f.Process("Depart #LAX on D(2023-05-01T10:00+02:00), T24(2023-05-01T22:30+02:00)\nArrive; late D(2023-05-02T08:00Z)\nNo dates\n", formatter.NewCounter())
+	got := icsCalendar(calendarEvents(processed), time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	if n := strings.Count(got, "BEGIN:VEVENT\r\n"); n != 3 {
+		t.Errorf("%d VEVENTs, want 3", n)
+	}
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Text-Formatter//Itinerary//EN",
+		"BEGIN:VEVENT",
+		"UID:1-20230501T080000Z@text-formatter",
+		"DTSTAMP:20230401T120000Z",
+		"DTSTART;VALUE=DATE:20230501",
+		`SUMMARY:Depart Los Angeles International Airport on 01 May 2023\, 22:30 (+0`,
+		" 2:00)",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:2-20230501T203000Z@text-formatter",
+		"DTSTAMP:20230401T120000Z",
+		"DTSTART:20230501T203000Z",
+		`SUMMARY:Depart Los Angeles International Airport on 01 May 2023\, 22:30 (+0`,
+		" 2:00)",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:3-20230502T080000Z@text-formatter",
+		"DTSTAMP:20230401T120000Z",
+		"DTSTART;VALUE=DATE:20230502",
+		`SUMMARY:Arrive\; late 02 May 2023`,
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got != want {
+		t.Errorf("icsCalendar =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := writeFile(t, dir, "log.txt", "Earlier entry\n")
+
+	for _, content := range []string{"Day 1: #LAX\n", "Day 2: #JFK\n"} {
+		input := writeFile(t, dir, "trip.txt", content)
+		if _, stderr, code := runCLI(t, "-append", input, output, lookup); code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+	}
+	want := "Earlier entry\nDay 1: Los Angeles International Airport\nDay 2: John F Kennedy International Airport\n"
+	if got := readFile(t, output); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// Without -append the output is replaced.
+	if _, stderr, code := runCLI(t, filepath.Join(dir, "trip.txt"), output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "Day 2: John F Kennedy International Airport\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestJSONResult(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX to #ZZZ on D(2023-05-01T10:00Z)\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	stdout, stderr, code := runCLI(t, "-json-result", input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("stdout is not a JSON object: %v\n%s", err, stdout)
+	}
+	for _, key := range []string{"output", "unresolved", "counts"} {
+		if _, exists := result[key]; !exists {
+			t.Errorf("result lacks the %q key: %s", key, stdout)
+		}
+	}
+
+	var got Result
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "From Los Angeles International Airport to #ZZZ on 01 May 2023\n"; got.Output != want {
+		t.Errorf("output = %q, want %q", got.Output, want)
+	}
+	if !slices.Equal(got.Unresolved, []string{"ZZZ"}) {
+		t.Errorf("unresolved = %q, want [ZZZ]", got.Unresolved)
+	}
+	if got.Counts[formatter.TokenAirport] != 1 || got.Counts[formatter.TokenDate] != 1 || got.Counts[formatter.TokenTime] != 0 {
+		t.Errorf("counts = %v", got.Counts)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output file written with -json-result: %v", err)
+	}
+}
+
+func TestRunLog(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX #ZZZ D(2023-05-01T10:00Z)\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+	logPath := filepath.Join(dir, "run.log")
+
+	if _, stderr, code := runCLI(t, "-log", logPath, input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	runCLI(t, "-log", logPath, filepath.Join(dir, "missing.txt"), output, lookup)
+
+	lines := strings.Split(strings.TrimSuffix(readFile(t, logPath), "\n"), "\n")
+	want := []string{
+		"started: input " + input + ", output " + output + ", airport data " + lookup,
+		"loaded 8 airport code(s) from " + lookup,
+		"expanded 1 airport(s), 1 date(s), 0 time(s); 1 unresolved code(s)",
+		"wrote " + output,
+		"finished in ",
+		"started: input " + filepath.Join(dir, "missing.txt"),
+		"error: Input file not found",
+		"finished in ",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("log has %d entries, want %d:\n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	timestamp := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+	for i, line := range lines {
+		prefix := timestamp.FindString(line)
+		if prefix == "" {
+			t.Errorf("entry %q has no timestamp", line)
+		}
+		if entry := strings.TrimPrefix(line, prefix); !strings.HasPrefix(entry, want[i]) {
+			t.Errorf("entry %d = %q, want it to start with %q", i+1, entry, want[i])
+		}
+	}
+}
+
+func TestHighlightPast(t *testing.T) {
+	f := loadTestAirports(t)
+	c := testConfig()
+	c.highlightPast = true
+	f.Now = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"D(2023-04-30T10:00Z)":      ColorGray,
+		"DSHORT(2023-04-01T00:00Z)": ColorGray,
+		"D(2023-05-01T11:00Z)":      ColorGray,
+		"D(2023-05-01T13:00Z)":      ColorMagenta,
+		"DLONG(2024-01-01T00:00Z)":  ColorMagenta,
+	}
+	for input, color := range tests {
+		want := color + f.FormatPlain(input) + ColorReset
+		if got := formatter.Render(f.Process(input, formatter.NewCounter()), highlightRenderer{f, c}); got != want {
+			t.Errorf("%s rendered %q, want %q", input, got, want)
+		}
+	}
+
+	c.highlightPast = false
+	if got, want := formatter.Render(f.Process("D(2023-04-30T10:00Z)", formatter.NewCounter()), highlightRenderer{f, c}), ColorMagenta+"30 Apr 2023"+ColorReset; got != want {
+		t.Errorf("without highlightPast rendered %q, want %q", got, want)
+	}
+}
+
+func TestJSONAirports(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX to *##KJFK\n")
+	lookup := writeFile(t, dir, "airports.json", `[
+		{"name": "Los Angeles International Airport", "iso_country": "US", "municipality": "Los Angeles", "icao_code": "KLAX", "iata_code": "LAX"},
+		{"name": "John F Kennedy International Airport", "iso_country": "US", "municipality": "New York", "icao_code": "KJFK", "iata_code": "JFK"}
+	]`)
+	output := filepath.Join(dir, "out.txt")
+
+	if _, stderr, code := runCLI(t, input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "From Los Angeles International Airport to New York\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	writeFile(t, dir, "airports.json", `[{"iata_code": "LAX"}]`)
+	if _, stderr, code := runCLI(t, input, output, lookup); code != 1 || !strings.Contains(stderr, "empty name in record") {
+		t.Errorf("exit code %d, stderr %q, want the nameless airport rejected", code, stderr)
+	}
+}
+
+func TestTZWrapInvalid(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	for _, wrap := range []string{"()", "%s/%s"} {
+		_, stderr, code := runCLI(t, "-tz-wrap", wrap, input, filepath.Join(dir, "out.txt"), lookup)
+		if code != 1 || !strings.Contains(stderr, "expected exactly one %s") {
+			t.Errorf("-tz-wrap %q: exit code %d, stderr %q, want exactly one %%s", wrap, code, stderr)
+		}
+	}
+}
+
+func TestPipelineFlag(t *testing.T) {
+	const expand = "expand(list, map, coordinates, country, icao, iata, dshort, dlong, dw, d, drel, dur, t12, t24)"
+	stdout, _, code := runCLI(t, "-pipeline", "-strip-invisible", "-trim-policy", "none")
+	if want := "strip-markers → strip-invisible → " + expand + "\n"; code != 0 || stdout != want {
+		t.Errorf("-pipeline: exit code %d, stdout %q, want %q", code, stdout, want)
+	}
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	// Renaming over a non-empty directory fails after the data is written.
+	target := filepath.Join(dir, "out.txt")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, target, "keep.txt", "kept")
+
+	c := testConfig()
+	c.writeRetries = 1
+	start := time.Now()
+	if err := c.writeFileAtomic(target, []byte("new content")); err == nil {
+		t.Fatal("writeFileAtomic succeeded, want an error")
+	}
+	if elapsed := time.Since(start); elapsed < writeRetryDelay {
+		t.Errorf("failed after %v, want a retry after %v", elapsed, writeRetryDelay)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "out.txt" {
+			t.Errorf("%s left behind after failed attempts", entry.Name())
+		}
+	}
+	if got := readFile(t, filepath.Join(target, "keep.txt")); got != "kept" {
+		t.Errorf("existing output changed to %q", got)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	target := writeFile(t, dir, "out.txt", "a much longer previous output")
+	if err := testConfig().writeFileAtomic(target, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, target); got != "new" {
+		t.Errorf("output = %q, want %q", got, "new")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the output directory, want only the output", len(entries))
+	}
+}
+
+func TestInsertLegHeaders(t *testing.T) {
+	f := loadTestAirports(t)
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "round trip in paragraphs",
+			input: "Day 1\nDepart #LAX\nArrive #JFK\n\nDay 5\nDepart #JFK\nArrive #LAX",
+			want: "Outbound\n\nDay 1\nDepart Los Angeles International Airport\nArrive John F Kennedy International Airport\n\n" +
+				"Return\n\nDay 5\nDepart John F Kennedy International Airport\nArrive Los Angeles International Airport",
+		},
+		{
+			name:  "single mention of the turnaround",
+			input: "#LAX\n*#CDG\n#LAX",
+			want:  "Outbound\n\nLos Angeles International Airport\nParis\n\nReturn\n\nLos Angeles International Airport",
+		},
+		{
+			name:  "one way",
+			input: "#LAX\n#JFK\n#CDG",
+		},
+		{
+			name:  "round trip on one line",
+			input: "#LAX to #CDG to #LAX",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed := f.Process(tt.input, formatter.NewCounter())
+			got, inserted := insertLegHeaders(f, processed)
+			if tt.want == "" {
+				if inserted || got != processed {
+					t.Errorf("insertLegHeaders = %q, %v, want the content unchanged", got, inserted)
+				}
+				return
+			}
+			if got := formatter.Render(got, formatter.PlainRenderer{}); !inserted || got != tt.want {
+				t.Errorf("insertLegHeaders = %q, %v, want %q", got, inserted, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendixColumns(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "Trip: #CDG\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	tests := map[string]string{
+		"city,iata":               "- Paris (CDG)",
+		" ICAO , name , country ": "- LFPG (Charles de Gaulle International Airport, FR)",
+		"display_name,iata":       "- CDG",
+		"coordinates":             "- 2.55, 49.012779",
+	}
+	for columns, want := range tests {
+		if _, stderr, code := runCLI(t, "-appendix", "-columns", columns, input, output, lookup); code != 0 {
+			t.Fatalf("-columns %q: exit code %d: %s", columns, code, stderr)
+		}
+		if got := readFile(t, output); !strings.HasSuffix(got, "\nFR\n"+want+"\n") {
+			t.Errorf("-columns %q: output = %q, want it to end with %q", columns, got, want)
+		}
+	}
+
+	_, stderr, code := runCLI(t, "-appendix", "-columns", "name,gate", input, output, lookup)
+	want := `Invalid -columns: unknown column "gate" (valid columns: city, coordinates, country, display_name, iata, icao, name)`
+	if code != 1 || !strings.Contains(stderr, want) {
+		t.Errorf("exit code %d, stderr %q, want %q", code, stderr, want)
+	}
+}
+
+func TestBase(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	base := writeFile(t, dir, "base.txt", "Day 1: #LAX\n\nDay 2: #JFK\nDay 3: #CDG\n")
+	output := filepath.Join(dir, "out.txt")
+	if _, stderr, code := runCLI(t, base, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	// Lines copied from the previous output keep any edits made to it.
+	writeFile(t, dir, "out.txt", strings.Replace(readFile(t, output), "Day 1: Los Angeles International Airport", "Day 1: LA", 1))
+
+	input := writeFile(t, dir, "trip.txt", "Day 1: #LAX\n\nDay 2: #LHR\nDay 3: #CDG\nDay 4: #JFK\n")
+	logPath := filepath.Join(dir, "run.log")
+	if _, stderr, code := runCLI(t, "-base", base, "-log", logPath, input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	want := "Day 1: LA\n\nDay 2: London Heathrow Airport\nDay 3: Charles de Gaulle International Airport\nDay 4: John F Kennedy International Airport\n"
+	if got := readFile(t, output); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if log := readFile(t, logPath); !strings.Contains(log, "reused 2 line(s) of the previous output") {
+		t.Errorf("log = %q, want 2 lines reused", log)
+	}
+}
+
+func TestBaseCannotReuse(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	base := writeFile(t, dir, "base.txt", "#LAX\n#JFK\n")
+	input := writeFile(t, dir, "trip.txt", "#LAX\n#CDG\n")
+	output := filepath.Join(dir, "out.txt")
+
+	_, stderr, _ := runCLI(t, "-base", base, input, output, lookup)
+	if !strings.Contains(stderr, "no previous output to reuse; processing every line") {
+		t.Errorf("stderr = %q, want the missing output reported", stderr)
+	}
+
+	writeFile(t, dir, "out.txt", "one line\n")
+	_, stderr, _ = runCLI(t, "-base", base, input, output, lookup)
+	if !strings.Contains(stderr, "cannot reuse the previous output (the base input has 2 non-blank lines but the previous output has 1)") {
+		t.Errorf("stderr = %q, want the mismatch reported", stderr)
+	}
+	if got, want := readFile(t, output), "Los Angeles International Airport\nCharles de Gaulle International Airport\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestBaseConflicts(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	base := writeFile(t, dir, "base.txt", "#LAX\n")
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	output := filepath.Join(dir, "out.txt")
+
+	for _, flags := range [][]string{
+		{"-strict"},
+		{"-require", "airports>=1"},
+		{"-appendix"},
+		{"-ics", filepath.Join(dir, "trip.ics")},
+		{"-legs"},
+		{"-oneline"},
+		{"-json-result"},
+	} {
+		args := append(append(flags, "-base", base), input, output, lookup)
+		_, stderr, code := runCLI(t, args...)
+		if want := flags[0] + " cannot be combined with -base"; code != 1 || !strings.Contains(stderr, want) {
+			t.Errorf("%s: exit code %d, stderr %q, want %q", flags[0], code, stderr, want)
+		}
+	}
+}
+
+func TestBanner(t *testing.T) {
+	tests := []struct {
+		title string
+		width int
+		want  string
+	}{
+		{"Processed Output", 0, "=== Processed Output ==="},
+		{"Processed Output", 23, "=== Processed Output ==="},
+		{"Processed Output", 30, "====== Processed Output ======"},
+		{"Processed Output", 31, "====== Processed Output ======="},
+		{"Überblick", 20, "==== Überblick ====="},
+	}
+	for _, tt := range tests {
+		if got := banner(tt.title, tt.width); got != tt.want {
+			t.Errorf("banner(%q, %d) = %q, want %q", tt.title, tt.width, got, tt.want)
+		}
+	}
+
+	// Output that is not a terminal gets the fixed-width banner.
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	stdout, stderr, code := runCLI(t, input, filepath.Join(dir, "out.txt"), lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "\n=== Processed Output ===\n\n"; !strings.HasPrefix(stdout, want) {
+		t.Errorf("stdout = %q, want it to start with %q", stdout, want)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX\nto ##QQQQ\nvia #ZZZ and #ZZZ\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	_, stderr, code := runCLI(t, "-strict", input, output, lookup)
+	want := "2 unresolved airport code(s):\nIATA: #ZZZ (line 3)\nICAO: ##QQQQ (line 2)"
+	if code != 1 || !strings.Contains(stderr, want) {
+		t.Errorf("exit code %d, stderr %q, want %q", code, stderr, want)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("output written despite -strict: %v", err)
+	}
+}
+
+func TestAddAirport(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#TST, *##ZZZZ and #LAX (##KLAX)\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	_, stderr, code := runCLI(t, "-add-airport", "tst=Test Field", "-add-airport", "ZZZZ=Private Strip,Nowhere,XX",
+		"-add-airport", "LAX=LA Hub, LA", input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "Test Field, Nowhere and LA Hub (LA Hub)\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	for value, want := range map[string]string{
+		"LAX":            `expected CODE=Name[,City[,Country]], got "LAX"`,
+		"LA=Los Angeles": `expected CODE=Name[,City[,Country]], got "LA=Los Angeles"`,
+		"LAX=":           `empty name in "LAX="`,
+		"LAX=A,B,C,D":    `too many fields in "LAX=A,B,C,D"`,
+	} {
+		var overrides airportOverrides
+		if err := overrides.Set(value); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Set(%q) error = %v, want %s", value, err, want)
+		}
+	}
+}
+
+func TestItinerarySummary(t *testing.T) {
+	f := loadTestAirports(t)
+	tests := map[string]string{
+		"#LAX D(2023-05-01T10:00Z)\n#JFK #JFK T24(2023-05-03T08:00Z)\n##LFPG": "LAX → JFK → CDG, 01–03 May 2023",
+		"#LAX to #JFK":         "LAX → JFK",
+		"D(2023-05-01T10:00Z)": "01 May 2023",
+		"D(2023-04-28T10:00Z) D(2023-05-03T10:00Z)": "28 Apr – 03 May 2023",
+		"D(2023-12-30T10:00Z) D(2024-01-02T10:00Z)": "30 Dec 2023 – 02 Jan 2024",
+		"#ZZZ and D(2023-13-01T10:00Z)":             noSummary,
+		"":                                          noSummary,
+	}
+	for input, want := range tests {
+		processed := f.Process(input, formatter.NewCounter())
+		if got := itinerarySummary(f, processed); got != want {
+			t.Errorf("itinerarySummary(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestOneline(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "Fly #LAX to #JFK on D(2023-05-01T10:00Z)\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	if _, stderr, code := runCLI(t, "-oneline", input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "LAX → JFK, 01 May 2023\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name, expected, actual, want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"changed line", "a\nb\nc", "a\nB\nc", "2: - b\n2: + B\n"},
+		{"added line", "a\nc", "a\nb\nc", "2: + b\n"},
+		{"removed lines", "a\nb\nc\nd", "a\nd", "2: - b\n3: - c\n"},
+		{"missing final newline", "a\n", "a", "2: - \n"},
+		{"change after common lines", "a\nb\nc\nd\ne", "a\nb\nC\nd\ne", "3: - c\n3: + C\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineDiff(tt.expected, tt.actual); got != tt.want {
+				t.Errorf("lineDiff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Differences too large to tabulate report their first differing line.
+	var expected, actual strings.Builder
+	for i := range 3000 {
+		fmt.Fprintf(&expected, "expected %d\n", i)
+		fmt.Fprintf(&actual, "actual %d\n", i)
+	}
+	got := lineDiff("same\n"+expected.String(), "same\n"+actual.String())
+	want := "2: - expected 0\n2: + actual 0\n(too many differences to list; only the first differing line is shown)\n"
+	if got != want {
+		t.Errorf("lineDiff of large inputs = %q, want %q", got, want)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX\nto #JFK\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	golden := writeFile(t, dir, "golden.txt", "From Los Angeles International Airport\nto John F Kennedy International Airport\n")
+	if _, stderr, code := runCLI(t, "-expect", golden, input, output, lookup); code != 0 {
+		t.Errorf("exit code %d: %s", code, stderr)
+	}
+
+	writeFile(t, dir, "golden.txt", "From Los Angeles International Airport\nto JFK\n")
+	_, stderr, code := runCLI(t, "-expect", golden, input, output, lookup)
+	want := "Output differs from " + golden + ":\n2: - to JFK\n2: + to John F Kennedy International Airport\n"
+	if code != 1 || !strings.Contains(stderr, want) {
+		t.Errorf("exit code %d, stderr %q, want %q", code, stderr, want)
+	}
+}
+
+func TestResolvePaths(t *testing.T) {
+	tests := []struct {
+		args                         []string
+		input, output, airportLookup string
+	}{
+		{[]string{"in.txt"}, "in.txt", "", ""},
+		{[]string{"in.txt", "out.txt"}, "in.txt", "out.txt", ""},
+		{[]string{"-", "-", "airports.csv"}, "-", "-", "airports.csv"},
+	}
+	for _, tt := range tests {
+		input, output, airportLookup, err := resolvePaths(tt.args)
+		if err != nil || input != tt.input || output != tt.output || airportLookup != tt.airportLookup {
+			t.Errorf("resolvePaths(%q) = %q, %q, %q, %v", tt.args, input, output, airportLookup, err)
+		}
+	}
+	for _, args := range [][]string{nil, {"a", "b", "c", "d"}} {
+		if _, _, _, err := resolvePaths(args); err == nil || !strings.Contains(err.Error(), "expected 1 to 3 arguments") {
+			t.Errorf("resolvePaths(%q) error = %v, want an argument count error", args, err)
+		}
+	}
+}
+
+func TestArgumentCounts(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	// One argument writes to stdout using the embedded airport data.
+	if stdout, stderr, code := runCLI(t, input); code != 0 || stdout != "Los Angeles International Airport\n" {
+		t.Errorf("one argument: exit code %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	for _, args := range [][]string{{input, output}, {input, output, lookup}} {
+		os.Remove(output)
+		if _, stderr, code := runCLI(t, args...); code != 0 {
+			t.Fatalf("%d arguments: exit code %d: %s", len(args), code, stderr)
+		}
+		if got, want := readFile(t, output), "Los Angeles International Airport\n"; got != want {
+			t.Errorf("%d arguments: output = %q, want %q", len(args), got, want)
+		}
+	}
+
+	if stdout, _, code := runCLI(t); code != 0 || !strings.Contains(stdout, "Itinerary usage:") {
+		t.Errorf("no arguments: exit code %d, stdout %q, want the usage", code, stdout)
+	}
+	_, stderr, code := runCLI(t, input, output, lookup, "extra")
+	if code != 1 || !strings.Contains(stderr, "expected 1 to 3 arguments (input [output [airport lookup]]), got 4") {
+		t.Errorf("four arguments: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestDumpGrammar(t *testing.T) {
+	stdout, stderr, code := runCLI(t, "-dump-grammar")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var placeholders []formatter.Placeholder
+	if err := json.Unmarshal([]byte(stdout), &placeholders); err != nil {
+		t.Fatalf("output is not a JSON array of placeholders: %v\n%s", err, stdout)
+	}
+	names := make(map[string]bool)
+	for _, p := range placeholders {
+		names[p.Name] = true
+		pattern, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			t.Errorf("%s: pattern %q does not compile: %v", p.Name, p.Pattern, err)
+			continue
+		}
+		if p.Example != "" && !pattern.MatchString(p.Example) {
+			t.Errorf("%s: pattern %q does not match its example %q", p.Name, p.Pattern, p.Example)
+		}
+	}
+	for _, name := range []string{"iata", "icao", "d", "t12", "t24"} {
+		if !names[name] {
+			t.Errorf("grammar has no %q placeholder", name)
+		}
+	}
+}
+
+func TestStdinStdout(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	stdin, err := os.Open(writeFile(t, dir, "trip.txt", "Fly #LAX to #JFK\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	set(t, &os.Stdin, stdin)
+
+	stdout, stderr, code := runCLI(t, "-", "-", lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := "Fly Los Angeles International Airport to John F Kennedy International Airport\n"; stdout != want {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+
+	// -max-file-size applies to stdin once it is read.
+	stdin.Seek(0, io.SeekStart)
+	_, stderr, code = runCLI(t, "-max-file-size", "5", "-", "-", lookup)
+	if code != 1 || !strings.Contains(stderr, "Input is 17 bytes, larger than -max-file-size of 5 bytes") {
+		t.Errorf("-max-file-size: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestColorFlags(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	output := filepath.Join(dir, "out.txt")
+
+	tests := []struct {
+		args  []string
+		color bool
+	}{
+		// Stdout and stderr are pipes here, so colors are off unless forced.
+		{nil, false},
+		{[]string{"-color"}, true},
+		{[]string{"-no-color"}, false},
+		{[]string{"-color", "-no-color"}, false},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runCLI(t, append(tt.args, input, output, lookup)...)
+		if code != 0 {
+			t.Fatalf("%q: exit code %d: %s", tt.args, code, stderr)
+		}
+		if !strings.Contains(stdout, "Los Angeles International Airport") {
+			t.Errorf("%q: stdout %q, want the processed output", tt.args, stdout)
+		}
+		if got := strings.Contains(stdout, "\033["); got != tt.color {
+			t.Errorf("%q: stdout %q has colors %v, want %v", tt.args, stdout, got, tt.color)
+		}
+		// Status messages follow the same decision.
+		if got := strings.Contains(stderr, "\033["); got != tt.color {
+			t.Errorf("%q: stderr %q has colors %v, want %v", tt.args, stderr, got, tt.color)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "From #LAX\n")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	stdout, stderr, code := runCLI(t, "-format", "json", input, "-", lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	var result struct {
+		Tokens []formatter.DetectedToken `json:"tokens"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+	}
+	want := []formatter.DetectedToken{{Kind: "iata", Raw: "#LAX", Value: "Los Angeles International Airport", Offset: 5, Line: 1}}
+	if !slices.Equal(result.Tokens, want) {
+		t.Errorf("tokens = %+v, want %+v", result.Tokens, want)
+	}
+
+	// Input without placeholders still lists an empty array.
+	writeFile(t, dir, "trip.txt", "No placeholders\n")
+	if stdout, _, _ = runCLI(t, "-format", "json", input, "-", lookup); !strings.Contains(stdout, `"tokens": []`) {
+		t.Errorf("stdout = %q, want an empty tokens array", stdout)
+	}
+
+	if _, stderr, code := runCLI(t, "-format", "yaml", input, "-", lookup); code != 1 || !strings.Contains(stderr, `Invalid -format "yaml" (expected text, html, markdown or json)`) {
+		t.Errorf("-format yaml: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestAirportCache(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	cachePath := filepath.Join(dir, "airports.csv.cache")
+	if got := airportCachePath(lookup); got != cachePath {
+		t.Fatalf("airportCachePath = %q, want %q", got, cachePath)
+	}
+	c := testConfig()
+
+	// The first load writes the cache, which later loads read.
+	if _, err := c.loadAirportData(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Fatalf("cache not written: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	source, err := filepath.Abs(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := []*formatter.Airport{{Name: "Cached Field", IATACode: "LAX"}}
+	if err := c.writeAirportCache(cachePath, airportCache{Source: source, Delimiter: ',', Airports: cached}); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(cachePath, future, future)
+	f, err := c.loadAirportData(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.FormatPlain("#LAX"); got != "Cached Field" {
+		t.Errorf("#LAX = %q, want %q", got, "Cached Field")
+	}
+
+	// A cache made for another file or delimiter is parsed again.
+	for _, cache := range []airportCache{
+		{Source: filepath.Join(dir, "other.csv"), Delimiter: ',', Airports: cached},
+		{Source: source, Delimiter: ';', Airports: cached},
+	} {
+		if err := c.writeAirportCache(cachePath, cache); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(cachePath, future, future)
+		if f, err = c.loadAirportData(lookup); err != nil {
+			t.Fatal(err)
+		}
+		if got := f.FormatPlain("#LAX"); got != "Los Angeles International Airport" {
+			t.Errorf("cache for %s with %q: #LAX = %q, want the lookup file's airport", cache.Source, cache.Delimiter, got)
+		}
+	}
+
+	// Lookup files differing only in extension have their own caches.
+	if got, other := airportCachePath(lookup), airportCachePath(filepath.Join(dir, "airports.tsv")); got == other {
+		t.Errorf("airports.csv and airports.tsv share the cache %s", got)
+	}
+
+	// A lookup file newer than its cache is parsed again.
+	if err := c.writeAirportCache(cachePath, airportCache{Source: source, Delimiter: ',', Airports: cached}); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(cachePath, future, future)
+	os.Chtimes(lookup, future.Add(time.Hour), future.Add(time.Hour))
+	f, err = c.loadAirportData(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.FormatPlain("#LAX"); got != "Los Angeles International Airport" {
+		t.Errorf("#LAX = %q, want %q", got, "Los Angeles International Airport")
+	}
+
+	// An unreadable cache is ignored.
+	writeFile(t, dir, "airports.csv.cache", "not a cache")
+	os.Chtimes(cachePath, future.Add(2*time.Hour), future.Add(2*time.Hour))
+	f, err = c.loadAirportData(lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.FormatPlain("#LAX"); got != "Los Angeles International Airport" {
+		t.Errorf("#LAX = %q, want %q", got, "Los Angeles International Airport")
+	}
+
+	// -no-cache neither reads nor writes the cache.
+	os.Remove(cachePath)
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	if _, stderr, code := runCLI(t, "-no-cache", input, filepath.Join(dir, "out.txt"), lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("cache written with -no-cache: %v", err)
+	}
+}
+
+func TestLookupDelimiter(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX to #JFK\n")
+	output := filepath.Join(dir, "out.txt")
+	const want = "Los Angeles International Airport to John F Kennedy International Airport\n"
+	for _, tt := range []struct {
+		file, separator string
+		args            []string
+	}{
+		{"airports.tsv", "\t", nil},
+		{"airports.psv", "|", nil},
+		{"airports.txt", ";", []string{"-delimiter", ";"}},
+		{"airports.dat", "\t", []string{"-delimiter", "tab"}},
+	} {
+		var data strings.Builder
+		for _, record := range [][]string{
+			{"name", "iso_country", "municipality", "icao_code", "iata_code", "coordinates"},
+			{"Los Angeles International Airport", "US", "Los Angeles", "KLAX", "LAX", "-118.408, 33.9425"},
+			{"John F Kennedy International Airport", "US", "New York", "KJFK", "JFK", "-73.7789, 40.6398"},
+		} {
+			data.WriteString(strings.Join(record, tt.separator) + "\n")
+		}
+		lookup := writeFile(t, dir, tt.file, data.String())
+		args := append(append([]string{"-no-cache"}, tt.args...), input, output, lookup)
+		if _, stderr, code := runCLI(t, args...); code != 0 {
+			t.Fatalf("%s: exit code %d: %s", tt.file, code, stderr)
+		}
+		if got := readFile(t, output); got != want {
+			t.Errorf("%s: output = %q, want %q", tt.file, got, want)
+		}
+	}
+
+	for _, value := range []string{"", ";;", `"`, "\n"} {
+		if _, err := parseDelimiter(value); err == nil {
+			t.Errorf("parseDelimiter(%q) accepted", value)
+		}
+	}
+	_, stderr, code := runCLI(t, "-delimiter", ";;", input, output, filepath.Join(dir, "airports.txt"))
+	if code != 1 || !strings.Contains(stderr, `Invalid -delimiter ";;"`) {
+		t.Errorf("-delimiter ;;: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestRemoteLookup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/airports.csv":
+			io.WriteString(w, testAirportsCSV)
+		case "/airports.json":
+			io.WriteString(w, `[{"name": "Hub", "iso_country": "US", "municipality": "Los Angeles", "icao_code": "KLAX", "iata_code": "LAX", "coordinates": "-118.408, 33.9425"}]`)
+		case "/broken.csv":
+			io.WriteString(w, "name\nHub\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX\n")
+	output := filepath.Join(dir, "out.txt")
+	for lookup, want := range map[string]string{
+		server.URL + "/airports.csv":  "Los Angeles International Airport\n",
+		server.URL + "/airports.json": "Hub\n",
+	} {
+		if _, stderr, code := runCLI(t, input, output, lookup); code != 0 {
+			t.Fatalf("%s: exit code %d: %s", lookup, code, stderr)
+		}
+		if got := readFile(t, output); got != want {
+			t.Errorf("%s: output = %q, want %q", lookup, got, want)
+		}
+	}
+
+	for lookup, want := range map[string]string{
+		server.URL + "/missing.csv": "Error fetching airport lookup file: " + server.URL + "/missing.csv returned 404 Not Found",
+		server.URL + "/broken.csv":  "Airport lookup file is malformed",
+	} {
+		if _, stderr, code := runCLI(t, input, output, lookup); code != 1 || !strings.Contains(stderr, want) {
+			t.Errorf("%s: exit code %d, stderr %q, want %q", lookup, code, stderr, want)
+		}
+	}
+}
+
+func TestCoordinateFormatFlag(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX is at C(#LAX)\n")
+	output := filepath.Join(dir, "out.txt")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	if _, stderr, code := runCLI(t, "-coordinate-format", "decimal", input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "Los Angeles International Airport is at 33.9425°N, 118.4080°W\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	_, stderr, code := runCLI(t, "-coordinate-format", "dms", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, `Invalid -coordinate-format "dms"`) {
+		t.Errorf("-coordinate-format dms: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestParseLengthRange(t *testing.T) {
+	tests := map[string][2]int{"4": {4, 4}, "3-5": {3, 5}, " 3 - 8 ": {3, 8}}
+	for value, want := range tests {
+		low, high, err := parseLengthRange(value)
+		if err != nil || low != want[0] || high != want[1] {
+			t.Errorf("parseLengthRange(%q) = %d, %d, %v, want %d, %d", value, low, high, err, want[0], want[1])
+		}
+	}
+	for _, value := range []string{"", "four", "2", "3-9", "5-3", "3-"} {
+		if _, _, err := parseLengthRange(value); err == nil {
+			t.Errorf("parseLengthRange(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX to #ZZZ\non D(2023-05-01T10:00Z)\n\nT24(2023-05-01T10:00Z)\n")
+	output := filepath.Join(dir, "out.txt")
+	ics := filepath.Join(dir, "trip.ics")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	stdout, stderr, code := runCLI(t, "-dry-run", "-ics", ics, input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	for _, path := range []string{output, ics, filepath.Join(dir, "airports.csv.cache")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s written by -dry-run: %v", filepath.Base(path), err)
+		}
+	}
+	for _, want := range []string{
+		"airport codes resolved: 1\n",
+		"dates formatted:        1\n",
+		"times formatted:        1\n",
+		"lines affected:         3 of 4\n",
+		"Dry run completed; no files were written",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr = %q, want %q", stderr, want)
+		}
+	}
+	if !strings.Contains(stdout, "Los Angeles International Airport") {
+		t.Errorf("stdout = %q, want the preview", stdout)
+	}
+}
+
+func TestTrimExemptFlag(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "Leg   Airport\n\t1     #LAX\n")
+	output := filepath.Join(dir, "out.txt")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	if _, stderr, code := runCLI(t, "-trim-exempt", `^\t`, input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if got, want := readFile(t, output), "Leg Airport\n\t1     Los Angeles International Airport\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	_, stderr, code := runCLI(t, "-trim-exempt", "(", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, `Invalid -trim-exempt "("`) {
+		t.Errorf("-trim-exempt (: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestBatchOutputPath(t *testing.T) {
+	tests := []struct{ input, outDir, want string }{
+		{"trips/paris.txt", "", "trips/paris.out.txt"},
+		{"notes", "", "notes.out"},
+		{"trips/paris.md", "out", "out/paris.out.md"},
+	}
+	for _, tt := range tests {
+		if got := batchOutputPath(tt.input, tt.outDir); got != tt.want {
+			t.Errorf("batchOutputPath(%q, %q) = %q, want %q", tt.input, tt.outDir, got, tt.want)
+		}
+	}
+	if !isBatchOutput("paris.out.txt") || isBatchOutput("paris.txt") || isBatchOutput("outline.txt") {
+		t.Error("isBatchOutput does not recognize batch output names")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	paris := writeFile(t, dir, "paris.txt", "#LAX to #CDG\n")
+	london := writeFile(t, dir, "london.txt", "#JFK to #LHR\n")
+	// Output of an earlier run is not processed again.
+	writeFile(t, dir, "old.out.txt", "#LAX\n")
+
+	pattern := filepath.Join(dir, "*.txt")
+	if got, want := expandInputPatterns([]string{pattern, paris}), []string{london, paris}; !slices.Equal(got, want) {
+		t.Errorf("expandInputPatterns = %q, want %q", got, want)
+	}
+
+	_, stderr, code := runCLI(t, "-batch", "-airports", lookup, pattern)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(dir, "paris.out.txt"):  "Los Angeles International Airport to Charles de Gaulle International Airport\n",
+		filepath.Join(dir, "london.out.txt"): "John F Kennedy International Airport to London Heathrow Airport\n",
+	} {
+		if got := readFile(t, path); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(path), got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.out.out.txt")); !os.IsNotExist(err) {
+		t.Errorf("earlier batch output processed: %v", err)
+	}
+
+	// A missing file fails on its own while the rest are written.
+	outDir := filepath.Join(dir, "processed")
+	_, stderr, code = runCLI(t, "-batch", "-airports", lookup, "-out-dir", outDir, paris, filepath.Join(dir, "missing.txt"))
+	if code != 1 || !strings.Contains(stderr, "1 of 2 input file(s) failed") {
+		t.Errorf("exit code %d, stderr %q, want one failed file", code, stderr)
+	}
+	if got := readFile(t, filepath.Join(outDir, "paris.out.txt")); !strings.HasPrefix(got, "Los Angeles") {
+		t.Errorf("paris.out.txt in -out-dir = %q", got)
+	}
+
+	_, stderr, code = runCLI(t, "-batch", "-ics", filepath.Join(dir, "trip.ics"), paris)
+	if code != 1 || !strings.Contains(stderr, "-ics cannot be combined with -batch") {
+		t.Errorf("-batch -ics: exit code %d, stderr %q", code, stderr)
+	}
+	_, stderr, code = runCLI(t, "-airports", lookup, paris, filepath.Join(dir, "out.txt"), lookup)
+	if code != 1 || !strings.Contains(stderr, "not both") {
+		t.Errorf("-airports with a lookup argument: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX & *#CDG on D(2023-05-01T10:00Z)\n")
+	output := filepath.Join(dir, "out.html")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	if _, stderr, code := runCLI(t, "-format", "html", input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	want := `<span class="airport">Los Angeles International Airport</span> &amp; <span class="city">Paris</span> on <span class="date">01 May 2023</span>` + "\n"
+	if got := readFile(t, output); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+
+	for _, args := range [][]string{
+		{"-format", "html", "-formats", "plain"},
+		{"-format", "html", "-side-by-side"},
+		{"-format", "xml"},
+	} {
+		if _, stderr, code := runCLI(t, append(args, input, output, lookup)...); code != 1 || !strings.Contains(stderr, "-format") {
+			t.Errorf("%q: exit code %d, stderr %q", args, code, stderr)
+		}
+	}
+}
+
+func TestFormatMarkdown(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX to *#CDG on D(2023-05-01T10:00Z), gate #4\n")
+	output := filepath.Join(dir, "out.md")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	if _, stderr, code := runCLI(t, "-format", "markdown", input, output, lookup); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	want := "**Los Angeles International Airport** to *Paris* on `01 May 2023`, gate \\#4\n"
+	if got := readFile(t, output); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	_, stderr, code := runCLI(t, "-format", "markdown", "-formats", "plain", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, "-format markdown cannot be combined with -formats") {
+		t.Errorf("-format markdown -formats: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestDefaultCity(t *testing.T) {
+	dir := t.TempDir()
+	input := writeFile(t, dir, "trip.txt", "#LAX to *#CDG\n")
+	output := filepath.Join(dir, "out.txt")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	for _, args := range [][]string{{"-default-city"}, {"-default-city", "-default-airport-form", "city"}} {
+		if _, stderr, code := runCLI(t, append(args, input, output, lookup)...); code != 0 {
+			t.Fatalf("%q: exit code %d: %s", args, code, stderr)
+		}
+		if got, want := readFile(t, output), "Los Angeles to Charles de Gaulle International Airport\n"; got != want {
+			t.Errorf("%q: output = %q, want %q", args, got, want)
+		}
+	}
+
+	_, stderr, code := runCLI(t, "-default-city", "-default-airport-form", "code", input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, "-default-city cannot be combined with -default-airport-form code") {
+		t.Errorf("exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestLookupFlag(t *testing.T) {
+	lookup := writeFile(t, t.TempDir(), "airports.csv", testAirportsCSV)
+	stdout, stderr, code := runCLI(t, "-airports", lookup, "-lookup", "jfk, EGLL,LAS")
+	if code != 1 {
+		t.Errorf("exit code %d, want 1 for the unknown code", code)
+	}
+	want := "JFK\n" +
+		"  Name:        John F Kennedy International Airport\n" +
+		"  City:        New York\n" +
+		"  Country:     US\n" +
+		"  ICAO:        KJFK\n" +
+		"  IATA:        JFK\n" +
+		"  Coordinates: -73.7789, 40.6398\n" +
+		"\n" +
+		"EGLL\n" +
+		"  Name:        London Heathrow Airport\n" +
+		"  City:        London\n" +
+		"  Country:     GB\n" +
+		"  ICAO:        EGLL\n" +
+		"  IATA:        LHR\n" +
+		"  Coordinates: -0.461941, 51.4706\n"
+	if stdout != want {
+		t.Errorf("stdout =\n%s\nwant\n%s", stdout, want)
+	}
+	if !strings.Contains(stderr, `Unknown airport code "LAS"; did you mean LAX, LHR?`) {
+		t.Errorf("stderr = %q, want the unknown code with suggestions", stderr)
+	}
+
+	_, stderr, code = runCLI(t, "-lookup", "JFK", "trip.txt")
+	if code != 1 || !strings.Contains(stderr, "-lookup takes no arguments") {
+		t.Errorf("-lookup with an argument: exit code %d, stderr %q", code, stderr)
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "theme.toml", "# Light background\n\nairport = \"blue\"\nDate = bold 208\nsuccess = none\n")
+	got, err := loadTheme(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultTheme()
+	want.Airport, want.Date, want.Success = ColorBlue, Bold+"\033[38;5;208m", ""
+	if got != want {
+		t.Errorf("loadTheme = %+v, want %+v", got, want)
+	}
+
+	for content, wantErr := range map[string]string{
+		"airport blue":    `line 1: expected role = color, got "airport blue"`,
+		"\nrunway = red":  `line 2: unknown role "runway"`,
+		"date = purple":   `line 1: unknown color "purple"`,
+		"date = bold 256": "line 1: color 256 is not from 0 to 255",
+	} {
+		_, err := loadTheme(writeFile(t, dir, "bad.toml", content))
+		if err == nil || err.Error() != wantErr {
+			t.Errorf("loadTheme(%q) error = %v, want %s", content, err, wantErr)
+		}
+	}
+}
+
+func TestThemeFlag(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	input := writeFile(t, dir, "trip.txt", "#LAX on D(2023-05-01T10:00Z)\n")
+	output := filepath.Join(dir, "out.txt")
+	lookup := writeFile(t, dir, "airports.csv", testAirportsCSV)
+	theme := writeFile(t, dir, "theme.toml", "airport = blue\nsuccess = none\n")
+	stdout, stderr, code := runCLI(t, "-theme", theme, "-color", input, output, lookup)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if want := ColorBlue + "Los Angeles International Airport" + ColorReset + " on " + ColorMagenta + "01 May 2023"; !strings.Contains(stdout, want) {
+		t.Errorf("stdout = %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, "Success: Processing completed successfully!"+ColorReset) || strings.Contains(stderr, ColorGreen) {
+		t.Errorf("stderr = %q, want an uncolored success message", stderr)
+	}
+
+	// ~/.itinerary.toml is read when -theme is not given.
+	writeFile(t, dir, ".itinerary.toml", "airport = red\n")
+	if stdout, _, _ = runCLI(t, "-color", input, output, lookup); !strings.Contains(stdout, ColorRed+"Los Angeles International Airport") {
+		t.Errorf("stdout = %q, want the airport in the default theme file's color", stdout)
+	}
+
+	writeFile(t, dir, ".itinerary.toml", "airport = purple\n")
+	_, stderr, code = runCLI(t, input, output, lookup)
+	if code != 1 || !strings.Contains(stderr, "Invalid theme file") {
+		t.Errorf("invalid default theme: exit code %d, stderr %q", code, stderr)
+	}
+}
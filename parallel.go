@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// parallelUnsupported returns an error explaining why content cannot be
+// processed in independent chunks with the options of f, or nil.
+func parallelUnsupported(f *formatter.Formatter) error {
+	switch {
+	case f.RespectCodeFences:
+		return errors.New("-respect-code-fences makes lines depend on their surroundings")
+	case f.Limits[formatter.TokenAirport] > 0 || f.Limits[formatter.TokenDate] > 0 || f.Limits[formatter.TokenTime] > 0:
+		return errors.New("expansion limits make lines depend on their position")
+	}
+	return nil
+}
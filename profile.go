@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// startProfiling starts CPU profiling to the -cpuprofile file and arranges
+// for a heap profile to be written to the -memprofile file; either may be
+// empty. The returned stop function flushes the profiles and must be called
+// before exiting. It also runs if the process is interrupted with SIGINT.
+func (c *config) startProfiling() (func(), error) {
+	var cpuFile *os.File
+	if c.cpuProfile != "" {
+		file, err := os.Create(c.cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		cpuFile = file
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			if c.memProfile != "" {
+				if err := writeHeapProfile(c.memProfile); err != nil {
+					c.printError(fmt.Sprintf("Error writing memory profile: %v", err))
+				}
+			}
+		})
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		stop()
+		os.Exit(130)
+	}()
+
+	return stop, nil
+}
+
+// writeHeapProfile writes a heap profile reflecting the current live data.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	runtime.GC()
+	return pprof.WriteHeapProfile(file)
+}
+
+// printTiming reports how long a phase took to stderr, with throughput when
+// the phase handled a known number of bytes.
+func (c *config) printTiming(phase string, elapsed time.Duration, bytes int) {
+	if bytes <= 0 || elapsed <= 0 {
+		fmt.Fprintf(c.stderr, "Timing: %s took %v\n", phase, elapsed)
+		return
+	}
+	throughput := float64(bytes) / (1 << 20) / elapsed.Seconds()
+	fmt.Fprintf(c.stderr, "Timing: %s took %v (%.2f MB/s)\n", phase, elapsed, throughput)
+}
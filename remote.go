@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// lookupFetchTimeout bounds the whole download of remote airport data.
+const lookupFetchTimeout = 30 * time.Second
+
+// isRemoteLookup reports whether the airport lookup path is an HTTP or HTTPS
+// URL rather than a local file.
+func isRemoteLookup(lookupPath string) bool {
+	return strings.HasPrefix(lookupPath, "http://") || strings.HasPrefix(lookupPath, "https://")
+}
+
+// fetchError is a failure to download remote airport data, as opposed to
+// data that was downloaded but could not be parsed.
+type fetchError struct {
+	err error
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+
+// fetchAirportData downloads airport data from rawURL and parses it like a
+// local file with the same extension, as CSV unless the URL path ends in
+// .json, .tsv or .psv. Remote data is never cached.
+func (c *config) fetchAirportData(rawURL string) ([]*formatter.Airport, error) {
+	location, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, &fetchError{err}
+	}
+	client := &http.Client{Timeout: lookupFetchTimeout}
+	response, err := client.Get(rawURL)
+	if err != nil {
+		return nil, &fetchError{err}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, &fetchError{fmt.Errorf("%s returned %s", rawURL, response.Status)}
+	}
+	if strings.EqualFold(path.Ext(location.Path), ".json") {
+		return formatter.ReadAirportsJSON(response.Body)
+	}
+	return formatter.ReadAirportsDelimited(response.Body, c.lookupDelimiter(location.Path))
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// highlightRenderer renders values in the theme's colors, for the terminal. f
+// supplies the airports for -color-by-country and the reference time for
+// -highlight-past.
+type highlightRenderer struct {
+	f      *formatter.Formatter
+	config *config
+}
+
+func (highlightRenderer) Text(text string) string { return text }
+
+func (r highlightRenderer) Value(value formatter.Value) string {
+	colors := r.config.colors
+	color := ColorReset
+	switch value.Kind {
+	case formatter.ValueAirport:
+		color = colors.Airport
+		if r.config.colorByCountry {
+			color = r.countryColor(value.Code)
+		}
+	case formatter.ValueCity:
+		color = colors.City
+	case formatter.ValueTime:
+		color = colors.Time
+	case formatter.ValueDate:
+		color = colors.Date
+		if r.config.highlightPast && isPastDate(value, r.f.ReferenceNow()) {
+			color = colors.Past
+		}
+	case formatter.ValueZone:
+		color = colors.Zone
+	case formatter.ValueDuration:
+		color = colors.Duration
+	case formatter.ValueMapLink:
+		color = colors.MapLink
+	case formatter.ValueCoordinates:
+		color = colors.Coordinates
+	case formatter.ValueCountry:
+		color = colors.Country
+	}
+	return fmt.Sprintf("%s%s%s", color, value.Text, ColorReset)
+}
+
+// isPastDate reports whether a date value's timestamp is before now.
+func isPastDate(value formatter.Value, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, value.Code)
+	return err == nil && t.Before(now)
+}
+
+// countryPalette holds the colors assigned to countries by countryColor.
+var countryPalette = []string{
+	ColorGreen, ColorCyan, ColorMagenta, ColorYellow, ColorBlue,
+	Bold + ColorGreen, Bold + ColorCyan, Bold + ColorMagenta, Bold + ColorYellow, Bold + ColorBlue,
+}
+
+// countryColor returns the palette color for the country of the airport
+// looked up by code, for -color-by-country. Countries take the palette
+// colors in the order they first appear in the run, so no two share a color
+// until the palette runs out. Unknown airports get the theme's airport
+// color.
+func (r highlightRenderer) countryColor(code string) string {
+	airport, exists := r.f.Lookup(code)
+	if !exists || airport.ISOCountry == "" {
+		return r.config.colors.Airport
+	}
+	if r.config.countryColors == nil {
+		r.config.countryColors = make(map[string]string)
+	}
+	color, assigned := r.config.countryColors[airport.ISOCountry]
+	if !assigned {
+		color = countryPalette[len(r.config.countryColors)%len(countryPalette)]
+		r.config.countryColors[airport.ISOCountry] = color
+	}
+	return color
+}
+
+// htmlRenderer escapes text and wraps values in spans with a class per kind.
+// Airports are linked with linkTemplate, as described for airportLink.
+type htmlRenderer struct {
+	linkTemplate string
+}
+
+func (htmlRenderer) Text(text string) string { return html.EscapeString(text) }
+
+func (r htmlRenderer) Value(value formatter.Value) string {
+	if value.Kind == formatter.ValueMapLink {
+		link := html.EscapeString(value.Text)
+		return fmt.Sprintf(`<a class="map" href="%s">%s</a>`, link, link)
+	}
+	class := "value"
+	switch value.Kind {
+	case formatter.ValueAirport:
+		class = "airport"
+	case formatter.ValueCity:
+		class = "city"
+	case formatter.ValueDate:
+		class = "date"
+	case formatter.ValueTime:
+		class = "time"
+	case formatter.ValueZone:
+		class = "zone"
+	case formatter.ValueCoordinates:
+		class = "coordinates"
+	case formatter.ValueDuration:
+		class = "duration"
+	case formatter.ValueCountry:
+		class = "country"
+	}
+	span := fmt.Sprintf(`<span class="%s">%s</span>`, class, html.EscapeString(value.Text))
+	if link := airportLink(value, r.linkTemplate); link != "" {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), span)
+	}
+	return span
+}
+
+// markdownEscaper escapes characters that Markdown would treat as formatting.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`, `~`, `\~`,
+)
+
+// markdownRenderer renders airports in bold, cities and countries in
+// italics and dates, times, durations and coordinates as inline code.
+// Airports are linked with linkTemplate, as described for airportLink.
+type markdownRenderer struct {
+	linkTemplate string
+}
+
+func (markdownRenderer) Text(text string) string { return markdownEscaper.Replace(text) }
+
+func (r markdownRenderer) Value(value formatter.Value) string {
+	if value.Kind == formatter.ValueMapLink {
+		return "<" + value.Text + ">"
+	}
+	if link := airportLink(value, r.linkTemplate); link != "" {
+		return "[" + markdownEscaper.Replace(value.Text) + "](" + markdownLinkEscaper.Replace(link) + ")"
+	}
+	switch value.Kind {
+	case formatter.ValueAirport:
+		return "**" + markdownEscaper.Replace(value.Text) + "**"
+	case formatter.ValueCity, formatter.ValueCountry:
+		return "*" + markdownEscaper.Replace(value.Text) + "*"
+	case formatter.ValueDate, formatter.ValueTime, formatter.ValueZone, formatter.ValueCoordinates, formatter.ValueDuration:
+		return "`" + value.Text + "`"
+	}
+	return markdownEscaper.Replace(value.Text)
+}
+
+// markdownLinkEscaper escapes characters that would end a Markdown link
+// destination early.
+var markdownLinkEscaper = strings.NewReplacer(`(`, `%28`, `)`, `%29`, ` `, `%20`)
+
+// airportLink returns the link URL for an airport or city value from
+// template, a URL template with {code} and {name} placeholders as given with
+// -link-template, or "" when template is empty or the value has no airport
+// code.
+func airportLink(value formatter.Value, template string) string {
+	if template == "" || value.Code == "" || (value.Kind != formatter.ValueAirport && value.Kind != formatter.ValueCity) {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{code}", url.PathEscape(value.Code),
+		"{name}", url.PathEscape(value.Text),
+	).Replace(template)
+}
+
+// sideBySideSeparator divides the columns of side-by-side output.
+const sideBySideSeparator = " | "
+
+// sideBySide lays out original and processed text in two columns, one line
+// each, with the original column padded to its widest line. When the line
+// counts differ (whitespace cleanup can merge or split lines) the shorter
+// side is padded with empty lines.
+func sideBySide(original, processed string) string {
+	left := strings.Split(strings.TrimRight(original, "\n"), "\n")
+	right := strings.Split(strings.TrimRight(processed, "\n"), "\n")
+
+	width := 0
+	for _, line := range left {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+
+	var b strings.Builder
+	for i := 0; i < max(len(left), len(right)); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(l))
+		b.WriteString(strings.TrimRight(l+padding+sideBySideSeparator+r, " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// formatExtensions lists the formats that can be written with -formats,
+// with the file extension used for each.
+var formatExtensions = map[string]string{
+	"plain":    ".txt",
+	"html":     ".html",
+	"markdown": ".md",
+}
+
+// renderer returns the renderer for a format of formatExtensions.
+func (c *config) renderer(format string) formatter.Renderer {
+	switch format {
+	case "html":
+		return htmlRenderer{linkTemplate: c.airportLinkTemplate}
+	case "markdown":
+		return markdownRenderer{linkTemplate: c.airportLinkTemplate}
+	}
+	return formatter.PlainRenderer{}
+}
+
+// writeFormats renders processed content once per requested format. Each
+// file is named after outputPath with the format's extension and written to
+// -out-dir, or to the directory of outputPath when it is not given.
+func (c *config) writeFormats(content string, formats []string, outputPath string) error {
+	for i, name := range formats {
+		formats[i] = strings.TrimSpace(name)
+		if _, exists := formatExtensions[formats[i]]; !exists {
+			return fmt.Errorf("unknown output format %q", formats[i])
+		}
+	}
+
+	outDir := c.outDir
+	if outDir == "" {
+		outDir = filepath.Dir(outputPath)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(outputPath), filepath.Ext(outputPath))
+	for _, format := range formats {
+		path := filepath.Join(outDir, base+formatExtensions[format])
+		if err := c.writeOutput(path, formatter.Render(content, c.renderer(format))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupEncoding returns the encoding registered under an IANA name such as
+// "iso-8859-1".
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", name)
+	}
+	return enc, nil
+}
+
+// encodeOutput transcodes text from UTF-8 to the -output-encoding.
+// Characters the encoding cannot represent are replaced with the encoding's
+// substitute character or reported as an error, per -on-unmappable.
+func (c *config) encodeOutput(text string) ([]byte, error) {
+	if c.outputEncoding == "" {
+		return []byte(text), nil
+	}
+	enc, err := lookupEncoding(c.outputEncoding)
+	if err != nil {
+		return nil, err
+	}
+	encoder := enc.NewEncoder()
+	if c.replaceUnmappable {
+		encoder = encoding.ReplaceUnsupported(encoder)
+	}
+	encoded, err := encoder.String(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode output as %s: %v", c.outputEncoding, err)
+	}
+	return []byte(encoded), nil
+}
+
+// writeRetryDelay is the pause before the first retry; it grows with each.
+const writeRetryDelay = 200 * time.Millisecond
+
+// writeOutput encodes text and writes it to path, appending with -append.
+// An empty path writes to stdout.
+func (c *config) writeOutput(path, text string) error {
+	data, err := c.encodeOutput(text)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err := c.stdout.Write(data)
+		return err
+	}
+	if !c.appendOutput {
+		return c.writeFileAtomic(path, data)
+	}
+	// Retrying an append could repeat a partly written chunk, so appends
+	// are attempted once.
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeFileAtomic replaces path with data so that readers never see a
+// partly written file: the data goes to a temporary file in the same
+// directory, which is renamed over path once complete. Failed attempts are
+// cleaned up and retried as often as -write-retries says.
+func (c *config) writeFileAtomic(path string, data []byte) error {
+	var err error
+	for attempt := 0; attempt <= c.writeRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * writeRetryDelay)
+		}
+		if err = writeTempAndRename(path, data); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// writeTempAndRename makes one attempt for writeFileAtomic.
+func writeTempAndRename(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// After a successful rename the temporary name no longer exists, so
+	// this only removes the leftovers of a failed attempt.
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Greatuyi/Text-Formatter/formatter"
+)
+
+// printSyntaxHelp prints every placeholder form with its example expanded
+// live against the example airports, so the cheat-sheet always matches the
+// tokenizer.
+func (c *config) printSyntaxHelp() {
+	// The example airports are valid, so this cannot fail.
+	f, _ := formatter.NewFromAirports(formatter.ExampleAirports)
+	f.Now, _ = formatter.ParseDateTime(formatter.ExampleTime)
+
+	var display formatter.Renderer = formatter.PlainRenderer{}
+	if c.useColor(c.stdout) {
+		display = highlightRenderer{f: f, config: c}
+	}
+	fmt.Fprintln(c.stdout, c.paint(c.stdout, Bold+Underline, "Placeholder syntax:"))
+	for _, spec := range f.Placeholders() {
+		expanded := formatter.Render(f.Process(spec.Example, formatter.NewCounter()), display)
+		fmt.Fprintf(c.stdout, "\n%s  %s\n", c.paint(c.stdout, Bold, spec.Syntax), spec.Help)
+		fmt.Fprintf(c.stdout, "  %s  ->  %s\n", c.paint(c.stdout, Italic, spec.Example), expanded)
+	}
+}
+
+// printGrammar prints the placeholder forms f recognizes, in precedence
+// order, as a JSON array so that editors can highlight placeholders exactly
+// as they are matched. The patterns use Go's RE2 syntax.
+func (c *config) printGrammar(f *formatter.Formatter) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(f.Placeholders())
+}
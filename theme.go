@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// theme holds the ANSI sequences each role is shown in on the terminal.
+type theme struct {
+	Airport     string
+	City        string
+	Date        string
+	Time        string
+	Zone        string
+	Duration    string
+	Country     string
+	Coordinates string
+	MapLink     string
+	Past        string
+	Title       string
+	Error       string
+	Warning     string
+	Success     string
+}
+
+// defaultTheme returns the colors used when no theme file is configured.
+func defaultTheme() theme {
+	return theme{
+		Airport:     ColorGreen,
+		City:        ColorCyan,
+		Date:        ColorMagenta,
+		Time:        ColorCyan,
+		Zone:        ColorYellow,
+		Duration:    ColorYellow,
+		Country:     Bold + ColorCyan,
+		Coordinates: ColorBlue,
+		MapLink:     ColorBlue + Underline,
+		Past:        ColorGray,
+		Title:       Bold + ColorBlue,
+		Error:       ColorRed + Bold,
+		Warning:     ColorYellow,
+		Success:     ColorGreen + Bold,
+	}
+}
+
+// useColor reports whether ANSI colors are written to w: never with
+// -no-color, always with -color, and otherwise when w is a terminal.
+func (c *config) useColor(w io.Writer) bool {
+	if c.noColor {
+		return false
+	}
+	if c.forceColor {
+		return true
+	}
+	file, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
+
+// paint returns text in color, followed by a reset, when colors are written
+// to w, and text unchanged otherwise.
+func (c *config) paint(w io.Writer, color, text string) string {
+	if !c.useColor(w) {
+		return text
+	}
+	return color + text + ColorReset
+}
+
+// roles maps the role names used in theme files to the fields of t.
+func (t *theme) roles() map[string]*string {
+	return map[string]*string{
+		"airport":     &t.Airport,
+		"city":        &t.City,
+		"date":        &t.Date,
+		"time":        &t.Time,
+		"zone":        &t.Zone,
+		"duration":    &t.Duration,
+		"country":     &t.Country,
+		"coordinates": &t.Coordinates,
+		"map":         &t.MapLink,
+		"past":        &t.Past,
+		"title":       &t.Title,
+		"error":       &t.Error,
+		"warning":     &t.Warning,
+		"success":     &t.Success,
+	}
+}
+
+// themeColors maps the color and style names accepted in theme files to
+// their ANSI sequences.
+var themeColors = map[string]string{
+	"black":     "\033[30m",
+	"red":       ColorRed,
+	"green":     ColorGreen,
+	"yellow":    ColorYellow,
+	"blue":      ColorBlue,
+	"magenta":   ColorMagenta,
+	"cyan":      ColorCyan,
+	"white":     "\033[37m",
+	"gray":      ColorGray,
+	"bold":      Bold,
+	"italic":    Italic,
+	"underline": Underline,
+	"none":      "",
+}
+
+// defaultThemePath returns ~/.itinerary.toml, the theme file read when
+// -theme is not given, or "" when the home directory is unknown.
+func defaultThemePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".itinerary.toml")
+}
+
+// loadTheme reads a theme file: one "role = color" line per role to change,
+// where color is one or more color or style names or 256-color numbers
+// separated by spaces, optionally quoted, e.g. date = "bold 208". Blank
+// lines and lines starting with "#" are ignored, and roles not listed keep
+// their default colors.
+func loadTheme(path string) (theme, error) {
+	t := defaultTheme()
+	file, err := os.Open(path)
+	if err != nil {
+		return t, err
+	}
+	defer file.Close()
+
+	roles := t.roles()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		role, value, found := strings.Cut(text, "=")
+		if !found {
+			return t, fmt.Errorf("line %d: expected role = color, got %q", line, text)
+		}
+		field, exists := roles[strings.ToLower(strings.TrimSpace(role))]
+		if !exists {
+			return t, fmt.Errorf("line %d: unknown role %q", line, strings.TrimSpace(role))
+		}
+		sequence, err := parseThemeColor(value)
+		if err != nil {
+			return t, fmt.Errorf("line %d: %v", line, err)
+		}
+		*field = sequence
+	}
+	return t, scanner.Err()
+}
+
+// parseThemeColor parses the color of a theme file line into an ANSI
+// sequence.
+func parseThemeColor(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	var sequence strings.Builder
+	for _, name := range strings.Fields(strings.ToLower(value)) {
+		if code, err := strconv.Atoi(name); err == nil {
+			if code < 0 || code > 255 {
+				return "", fmt.Errorf("color %d is not from 0 to 255", code)
+			}
+			fmt.Fprintf(&sequence, "\033[38;5;%dm", code)
+			continue
+		}
+		code, exists := themeColors[name]
+		if !exists {
+			return "", fmt.Errorf("unknown color %q", name)
+		}
+		sequence.WriteString(code)
+	}
+	return sequence.String(), nil
+}